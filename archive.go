@@ -0,0 +1,300 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cocoon
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// archiveFormat is chrysalis archive format, detected by magic bytes at offset
+type archiveFormat struct {
+	Name    string
+	Offset  int
+	Magic   []byte
+	Extract func(src, dest string, limits archiveLimits) error
+}
+
+// ErrUnknownArchive is returned for files without known archive signature
+var ErrUnknownArchive = errors.New("unknown archive format")
+
+var archiveFormats = []archiveFormat{
+	{Name: "zip", Magic: []byte("PK\x03\x04"), Extract: unzipWithLimits},
+	{Name: "tar.gz", Magic: []byte{0x1f, 0x8b}, Extract: untarGzip},
+	{Name: "tar.xz", Magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, Extract: untarXz},
+	{Name: "tar.zst", Magic: []byte{0x28, 0xb5, 0x2f, 0xfd}, Extract: untarZstd},
+	{Name: "tar", Offset: 257, Magic: []byte("ustar"), Extract: untarPlain},
+}
+
+// detectArchiveFormat reads archive header and returns its format
+func detectArchiveFormat(src string) (archiveFormat, error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return archiveFormat{}, err
+	}
+	defer file.Close()
+
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return archiveFormat{}, err
+	}
+	header = header[:n]
+
+	for _, format := range archiveFormats {
+		end := format.Offset + len(format.Magic)
+		if end <= len(header) && bytes.Equal(header[format.Offset:end], format.Magic) {
+			return format, nil
+		}
+	}
+	return archiveFormat{}, fmt.Errorf("%v: %w", src, ErrUnknownArchive)
+}
+
+// extractArchive extracts zip or tar archive into dest
+func extractArchive(src, dest string) error {
+	format, err := detectArchiveFormat(src)
+	if err != nil {
+		return err
+	}
+	LogInfo(fmt.Sprintf("Extract %v archive %v into %v", format.Name, src, dest))
+	return format.Extract(src, dest, defaultArchiveLimits)
+}
+
+func untarPlain(src, dest string, limits archiveLimits) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return untar(file, src, dest, limits)
+}
+
+func untarGzip(src, dest string, limits archiveLimits) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return untar(r, src, dest, limits)
+}
+
+func untarXz(src, dest string, limits archiveLimits) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r, err := xz.NewReader(file)
+	if err != nil {
+		return err
+	}
+	return untar(r, src, dest, limits)
+}
+
+func untarZstd(src, dest string, limits archiveLimits) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r, err := zstd.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return untar(r, src, dest, limits)
+}
+
+// untar extracts tar stream into dest, keeping file modes, so executables stay runnable
+func untar(r io.Reader, src, dest string, limits archiveLimits) error {
+	err := os.MkdirAll(dest, 0755)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	entries := 0
+	remaining := limits.MaxSize
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		entries++
+		if entries > limits.MaxEntries {
+			return fmt.Errorf("%v: %w", src, ErrTooManyEntries)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeXGlobalHeader, tar.TypeXHeader:
+			continue
+		}
+
+		path, err := safeArchivePath(dest, header.Name)
+		if err != nil {
+			return err
+		}
+		if err := checkArchiveLinks(dest, path); err != nil {
+			return err
+		}
+		mode := header.FileInfo().Mode()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, dirMode(mode)); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if header.Size > remaining {
+				return fmt.Errorf("%v: %w", src, ErrArchiveTooLarge)
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			written, err := writeArchiveFile(path, mode.Perm(), io.LimitReader(tr, remaining+1))
+			if err != nil {
+				return err
+			}
+			remaining -= written
+			if remaining < 0 {
+				return fmt.Errorf("%v: %w", src, ErrArchiveTooLarge)
+			}
+		case tar.TypeSymlink:
+			if err := writeArchiveSymlink(dest, path, header); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			target, err := safeArchivePath(dest, header.Linkname)
+			if err != nil {
+				return err
+			}
+			if err := checkArchiveLinks(dest, target); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			_ = os.Remove(path)
+			if err := os.Link(target, path); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%q: unsupported tar entry type %q", header.Name, header.Typeflag)
+		}
+	}
+}
+
+// writeArchiveSymlink creates symbolic link, which target stays inside dest, e.g. JDK legal/java.sql/LICENSE -> ../java.base/LICENSE
+func writeArchiveSymlink(dest, path string, header *tar.Header) error {
+	if err := checkSymlinkTarget(dest, path, header.Linkname); err != nil {
+		return fmt.Errorf("%q -> %q: %w", header.Name, header.Linkname, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	_ = os.Remove(path)
+	return os.Symlink(filepath.FromSlash(strings.Replace(header.Linkname, "\\", "/", -1)), path)
+}
+
+// checkSymlinkTarget rejects link at path, which target is absolute, leads out of dest or passes through other links.
+// Target is resolved step by step, so b -> a/.. where a -> . is rejected too.
+func checkSymlinkTarget(dest, path, linkname string) error {
+	slashed := strings.Replace(linkname, "\\", "/", -1)
+	if slashed == "" || strings.HasPrefix(slashed, "/") || filepath.IsAbs(linkname) ||
+		filepath.VolumeName(linkname) != "" || (len(slashed) > 1 && slashed[1] == ':') {
+		return ErrUnsafePath
+	}
+	root := filepath.Clean(dest)
+	target := filepath.Dir(path)
+	for _, part := range strings.Split(slashed, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if target == root {
+				return ErrUnsafePath
+			}
+			target = filepath.Dir(target)
+			continue
+		}
+		target = filepath.Join(target, part)
+		if fi, err := os.Lstat(target); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return ErrUnsafePath
+		}
+	}
+	return nil
+}
+
+// checkArchiveLinks rejects path, which itself or its parent folders inside dest are symbolic links, so entries never are written through links
+func checkArchiveLinks(dest, path string) error {
+	root := filepath.Clean(dest)
+	for dir := path; len(dir) > len(root); dir = filepath.Dir(dir) {
+		fi, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%v: %w", path, ErrUnsafePath)
+		}
+	}
+	return nil
+}
+
+// writeArchiveFile writes file with exact permissions, not masked by umask
+func writeArchiveFile(path string, perm os.FileMode, r io.Reader) (int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(f, r)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return written, err
+}
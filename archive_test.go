@@ -0,0 +1,228 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cocoon
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+type testTarEntry struct {
+	name     string
+	typeflag byte
+	mode     int64
+	body     string
+	linkname string
+}
+
+func writeTestTar(t *testing.T, dir string, gzipped bool, entries []testTarEntry) string {
+	name := filepath.Join(dir, "chrysalis.tar")
+	if gzipped {
+		name += ".gz"
+	}
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var out io.Writer = file
+	if gzipped {
+		gw := gzip.NewWriter(file)
+		defer gw.Close()
+		out = gw
+	}
+	w := tar.NewWriter(out)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Typeflag: entry.typeflag, Mode: entry.mode, Linkname: entry.linkname, Format: tar.FormatPAX}
+		if header.Typeflag == 0 {
+			header.Typeflag = tar.TypeReg
+		}
+		if header.Mode == 0 {
+			header.Mode = 0644
+		}
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(entry.body))
+		}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(entry.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestUntarHostileArchives(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []testTarEntry
+		want    error
+	}{
+		{"traversal", []testTarEntry{{name: "../evil.txt", body: "evil"}}, ErrUnsafePath},
+		{"nested traversal", []testTarEntry{{name: "bin/../../evil.txt", body: "evil"}}, ErrUnsafePath},
+		{"absolute", []testTarEntry{{name: "/tmp/evil.txt", body: "evil"}}, ErrUnsafePath},
+		{"absolute symlink", []testTarEntry{{name: "link", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"}}, ErrUnsafePath},
+		{"escaping symlink", []testTarEntry{{name: "legal/link", typeflag: tar.TypeSymlink, linkname: "../../evil.txt"}}, ErrUnsafePath},
+		{"write through symlink", []testTarEntry{
+			{name: "up", typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "up/evil.txt", body: "evil"},
+		}, ErrUnsafePath},
+		{"chained symlink", []testTarEntry{
+			{name: "up", typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "out", typeflag: tar.TypeSymlink, linkname: "up/.."},
+		}, ErrUnsafePath},
+		{"escaping hardlink", []testTarEntry{{name: "link", typeflag: tar.TypeLink, linkname: "../evil.txt"}}, ErrUnsafePath},
+		{"too many entries", []testTarEntry{{name: "a"}, {name: "b"}, {name: "c"}}, ErrTooManyEntries},
+		{"too large", []testTarEntry{{name: "bomb", body: string(make([]byte, 2048))}}, ErrArchiveTooLarge},
+	}
+
+	limits := archiveLimits{MaxEntries: 2, MaxSize: 1024}
+	for _, test := range tests {
+		for _, gzipped := range []bool{false, true} {
+			name := test.name
+			if gzipped {
+				name += " gzip"
+			}
+			t.Run(name, func(t *testing.T) {
+				if runtime.GOOS == "windows" && test.entries[0].typeflag == tar.TypeSymlink {
+					t.Skip("symbolic links require privileges on windows")
+				}
+				dir, err := ioutil.TempDir("", "cocoon")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(dir)
+
+				archive := writeTestTar(t, dir, gzipped, test.entries)
+				format, err := detectArchiveFormat(archive)
+				if err != nil {
+					t.Fatal(err)
+				}
+				dest := filepath.Join(dir, "runtime", "hostile")
+				if err := format.Extract(archive, dest, limits); !errors.Is(err, test.want) {
+					t.Fatalf("untar error = %v, want %v", err, test.want)
+				}
+				if _, err := os.Stat(filepath.Join(dir, "runtime", "evil.txt")); !os.IsNotExist(err) {
+					t.Errorf("file is written outside of destination")
+				}
+				if _, err := os.Stat(filepath.Join(dir, "evil.txt")); !os.IsNotExist(err) {
+					t.Errorf("file is written outside of destination")
+				}
+			})
+		}
+	}
+}
+
+func TestUntarValidArchive(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges on windows")
+	}
+	dir, err := ioutil.TempDir("", "cocoon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := writeTestTar(t, dir, true, []testTarEntry{
+		{name: "jdk/bin/", typeflag: tar.TypeDir, mode: 0755},
+		{name: "jdk/bin/java", mode: 0755, body: "#!/bin/sh\n"},
+		{name: "jdk/bin/javaw", typeflag: tar.TypeLink, linkname: "jdk/bin/java"},
+		{name: "jdk/legal/java.base/LICENSE", body: "license\n"},
+		{name: "jdk/legal/java.sql/LICENSE", typeflag: tar.TypeSymlink, linkname: "../java.base/LICENSE"},
+	})
+	dest := filepath.Join(dir, "runtime", "jdk")
+	if err := extractArchive(archive, dest); err != nil {
+		t.Fatal(err)
+	}
+
+	if fi, err := os.Stat(filepath.Join(dest, "jdk", "bin", "java")); err != nil || fi.Mode().Perm() != 0755 {
+		t.Errorf("java is not extracted with mode 0755: %v", err)
+	}
+	if fi, err := os.Stat(filepath.Join(dest, "jdk", "bin", "javaw")); err != nil || fi.Mode().Perm() != 0755 {
+		t.Errorf("javaw hard link is not extracted: %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dest, "jdk", "legal", "java.sql", "LICENSE"))
+	if err != nil || string(data) != "license\n" {
+		t.Errorf("java.sql/LICENSE = %q, %v", data, err)
+	}
+
+	if err := writeManifest(dest); err != nil {
+		t.Fatal(err)
+	}
+	drift, err := verifyManifest(dest)
+	if err != nil || !drift.Empty() {
+		t.Fatalf("verifyManifest = %+v, %v", drift, err)
+	}
+	link := filepath.Join(dest, "jdk", "legal", "java.sql", "LICENSE")
+	if err := os.Remove(link); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../bin/java", link); err != nil {
+		t.Fatal(err)
+	}
+	drift, err = verifyManifest(dest)
+	if err != nil || len(drift.Modified) != 1 {
+		t.Errorf("verifyManifest after link change = %+v, %v", drift, err)
+	}
+}
+
+func TestStripRejectsEscapingSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges on windows")
+	}
+	for _, spec := range []string{StripAuto, "1"} {
+		t.Run(spec, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "cocoon")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			// link stays inside archive root, but leads out of chrysalis after wrapping folder is stripped
+			archive := writeTestTar(t, dir, false, []testTarEntry{
+				{name: "jdk/bin/", typeflag: tar.TypeDir, mode: 0755},
+				{name: "jdk/bin/java", typeflag: tar.TypeSymlink, linkname: "../../outside"},
+			})
+			dest := filepath.Join(dir, "runtime", "jdk")
+			if err := extractArchive(archive, dest); err != nil {
+				t.Fatal(err)
+			}
+			if err := stripChrysalis(dest, spec); !errors.Is(err, ErrUnsafePath) {
+				t.Fatalf("stripChrysalis error = %v, want %v", err, ErrUnsafePath)
+			}
+		})
+	}
+}
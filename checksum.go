@@ -0,0 +1,152 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cocoon
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	initfile "gopkg.in/ini.v1"
+)
+
+// checksumSuffix is extension of sidecar file with archive SHA-256, as produced by sha256sum
+const checksumSuffix = ".sha256"
+
+// ErrChecksumMismatch is returned when archive digest differs from expected one
+var ErrChecksumMismatch = errors.New("SHA-256 checksum mismatch")
+
+// fileSHA256 returns hex encoded SHA-256 of file content
+func fileSHA256(name string) (string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// parseSHA256 accepts bare hex digest or '<digest>  <file name>' sha256sum line
+func parseSHA256(value string) (string, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return "", errors.New("empty SHA-256 value")
+	}
+	digest := strings.ToLower(fields[0])
+	if decoded, err := hex.DecodeString(digest); err != nil || len(decoded) != sha256.Size {
+		return "", fmt.Errorf("invalid SHA-256 value: %q", fields[0])
+	}
+	return digest, nil
+}
+
+// expectedSHA256 returns given digest, or digest from <archive>.sha256 sidecar file. Empty string means nothing to verify.
+func expectedSHA256(archive, expected string) (string, error) {
+	if expected != "" {
+		return parseSHA256(expected)
+	}
+	sidecar := archive + checksumSuffix
+	data, err := ioutil.ReadFile(sidecar)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	digest, err := parseSHA256(string(data))
+	if err != nil {
+		return "", fmt.Errorf("%v: %w", sidecar, err)
+	}
+	return digest, nil
+}
+
+// copyArchive copies archive into private temporary file in dir and returns its name with SHA-256 of copied content.
+// Copy is verified and extracted, so archive can not be replaced between checks and extraction.
+func copyArchive(archive, dir, prefix string) (name, digest string, err error) {
+	src, err := os.Open(archive)
+	if err != nil {
+		return "", "", err
+	}
+	defer src.Close()
+
+	dst, err := ioutil.TempFile(dir, prefix)
+	if err != nil {
+		return "", "", err
+	}
+	name = dst.Name()
+	defer func() {
+		closeInto(dst, &err)
+		if err != nil {
+			_ = os.Remove(name)
+		}
+	}()
+
+	hash := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(dst, hash), src); err != nil {
+		return "", "", err
+	}
+	return name, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// verifyArchiveChecksum compares archive digest got with expected one. Returns verified digest, or empty string if there is nothing to verify.
+func verifyArchiveChecksum(archive, expected, got string) (string, error) {
+	want, err := expectedSHA256(archive, expected)
+	if err != nil || want == "" {
+		return "", err
+	}
+	if got != want {
+		return "", fmt.Errorf("%v: %w: expected %v, got %v", archive, ErrChecksumMismatch, want, got)
+	}
+	return got, nil
+}
+
+// setChrysalisDigest remembers verified archive digest of chrysalis in [chrysalis.sha256] section
+func setChrysalisDigest(name, digest string, cfg *initfile.File) {
+	section := cfg.Section("chrysalis.sha256")
+	if digest == "" {
+		section.DeleteKey(name)
+		return
+	}
+	section.Key(name).SetValue(digest)
+}
+
+// applyChrysalisDigest sets [chrysalis].sha256 to verified digest of active chrysalis, or removes it if chrysalis was not verified
+func applyChrysalisDigest(name string, cfg *initfile.File) {
+	digest := cfg.Section("chrysalis.sha256").Key(name).String()
+	if digest == "" {
+		cfg.Section("chrysalis").DeleteKey("sha256")
+		return
+	}
+	cfg.Section("chrysalis").Key("sha256").SetValue(digest)
+	logMorphInfo("chrysalis", "sha256", digest)
+}
@@ -0,0 +1,157 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cocoon
+
+// cocoon.exe metamorphose list --format=json
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	initfile "gopkg.in/ini.v1"
+)
+
+// Chrysalis list output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// ChrysalisInfo describes chrysalis, installed into [chrysalis].dir.base
+type ChrysalisInfo struct {
+	Name       string    `json:"name"`
+	Path       string    `json:"path"`
+	Dir64bit   string    `json:"dir64bit,omitempty"`
+	Dir32bit   string    `json:"dir32bit,omitempty"`
+	InitScript string    `json:"initscript,omitempty"`
+	Size       int64     `json:"size"`
+	Installed  time.Time `json:"installed"`
+	Active     bool      `json:"active"`
+}
+
+// dirSize returns total size of files in folder
+func dirSize(path string) int64 {
+	var size int64
+	_ = filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// readChrysalisInfo reads chrysalis folder and its chrysalis.ini description
+func readChrysalisInfo(path string, fi os.FileInfo) ChrysalisInfo {
+	info := ChrysalisInfo{
+		Name:      fi.Name(),
+		Path:      path,
+		Size:      dirSize(path),
+		Installed: fi.ModTime(),
+	}
+	descriptionFile := filepath.Join(path, "chrysalis.ini")
+	if _, err := os.Stat(descriptionFile); err == nil {
+		if description, descErr := initfile.Load(descriptionFile); descErr == nil {
+			info.Dir64bit = description.Section("").Key("dir.64bit").String()
+			info.Dir32bit = description.Section("").Key("dir.32bit").String()
+			info.InitScript = description.Section("").Key("initscript").String()
+		}
+	}
+	return info
+}
+
+// ListChrysalises returns chrysalises from [chrysalis].dir.base folder
+func ListChrysalises(cfg *initfile.File) ([]ChrysalisInfo, error) {
+	basedir := GetAbsolutePath(getChrysalisBaseDir(cfg))
+	// active chrysalis is the one config file points to, environment override is not taken into account
+	active := cfg.Section("chrysalis").Key("dir.version").String()
+
+	files, err := ioutil.ReadDir(basedir)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]ChrysalisInfo, 0)
+	for _, v := range files {
+		if !v.IsDir() || isStagingDir(v.Name()) {
+			continue
+		}
+		info := readChrysalisInfo(filepath.Join(basedir, v.Name()), v)
+		info.Active = v.Name() == active
+		result = append(result, info)
+	}
+	return result, nil
+}
+
+func writeChrysalisList(list []ChrysalisInfo, format string, w io.Writer) error {
+	if format == FormatJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(list)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tNAME\t64BIT\t32BIT\tINITSCRIPT\tSIZE\tINSTALLED\tPATH")
+	for _, info := range list {
+		mark := ""
+		if info.Active {
+			mark = "*"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", mark, info.Name, info.Dir64bit, info.Dir32bit, info.InitScript,
+			formatSize(info.Size), info.Installed.Format("2006-01-02 15:04:05"), info.Path)
+	}
+	return tw.Flush()
+}
+
+// MetamorphoseList prints installed chrysalises in text or json format
+func MetamorphoseList(format string, cfg *initfile.File, w io.Writer) bool {
+	list, err := ListChrysalises(cfg)
+	if err != nil {
+		reportMorphError(err)
+		return false
+	}
+	if err := writeChrysalisList(list, format, w); err != nil {
+		reportMorphError(err)
+		return false
+	}
+	return true
+}
@@ -86,9 +86,9 @@ func GetAbsolutePath(somepath string) string {
 }
 
 func getCocoonInitScript(cfg *initfile.File) string {
-	initScriptName := cfg.Section("cocoon").Key("startup").Validate(func(in string) string {
+	initScriptName := configKeyOf(cfg, "cocoon", "startup").Validate(func(in string) string {
 		if len(in) == 0 {
-			return "cocoon_init.cmd"
+			return "cocoon_init" + scriptExt
 		}
 		return in
 	})
@@ -97,7 +97,7 @@ func getCocoonInitScript(cfg *initfile.File) string {
 }
 
 func getCocoonLogFilename(cfg *initfile.File) string {
-	logName := cfg.Section("cocoon").Key("log.file").Validate(func(in string) string {
+	logName := configKeyOf(cfg, "cocoon", "log.file").Validate(func(in string) string {
 		if len(in) == 0 {
 			return "CocoonProcess"
 		}
@@ -107,7 +107,7 @@ func getCocoonLogFilename(cfg *initfile.File) string {
 }
 
 func getCocoonLogLevel(cfg *initfile.File) string {
-	logLevel := cfg.Section("cocoon").Key("log.level").Validate(func(in string) string {
+	logLevel := configKeyOf(cfg, "cocoon", "log.level").Validate(func(in string) string {
 		if len(in) == 0 {
 			return "error"
 		}
@@ -116,8 +116,18 @@ func getCocoonLogLevel(cfg *initfile.File) string {
 	return logLevel
 }
 
+func getCocoonLogSink(cfg *initfile.File) string {
+	logSink := configKeyOf(cfg, "cocoon", "log.sink").Validate(func(in string) string {
+		if len(in) == 0 {
+			return defaultLogSink
+		}
+		return in
+	})
+	return logSink
+}
+
 func getCocoonUsepipe(cfg *initfile.File) bool {
-	usePipe := cfg.Section("cocoon").Key("usepipe").Validate(func(in string) string {
+	usePipe := configKeyOf(cfg, "cocoon", "usepipe").Validate(func(in string) string {
 		if len(in) == 0 {
 			return "false"
 		}
@@ -126,15 +136,31 @@ func getCocoonUsepipe(cfg *initfile.File) bool {
 	return strings.EqualFold(usePipe, "true") || strings.EqualFold(usePipe, "yes")
 }
 
-func getChrystalisVersionPath(cfg *initfile.File) string {
-	basedir := cfg.Section("chrysalis").Key("dir.base").Validate(func(in string) string {
+func getCocoonSingleInstance(cfg *initfile.File) string {
+	mode := configKeyOf(cfg, "cocoon", "single.instance").Validate(func(in string) string {
+		switch strings.ToLower(in) {
+		case SingleInstanceExit, SingleInstanceForward:
+			return strings.ToLower(in)
+		}
+		return SingleInstanceOff
+	})
+	return mode
+}
+
+func getChrysalisBaseDir(cfg *initfile.File) string {
+	basedir := configKeyOf(cfg, "chrysalis", "dir.base").Validate(func(in string) string {
 		if len(in) == 0 {
 			return "runtime"
 		}
 		return in
 	})
+	return basedir
+}
 
-	versiondir := cfg.Section("chrysalis").Key("dir.version").String()
+func getChrystalisVersionPath(cfg *initfile.File) string {
+	basedir := getChrysalisBaseDir(cfg)
+
+	versiondir := configKeyOf(cfg, "chrysalis", "dir.version").String()
 	return filepath.Join(basedir, versiondir)
 }
 
@@ -142,7 +168,7 @@ func getChrystalisPath(cfg *initfile.File, is64bit bool) string {
 	versiondir := getChrystalisVersionPath(cfg)
 
 	archsuffix := is64bitToString(is64bit)
-	archdir := cfg.Section("chrysalis").Key("dir." + archsuffix).Validate(func(in string) string {
+	archdir := configKeyOf(cfg, "chrysalis", "dir."+archsuffix).Validate(func(in string) string {
 		if len(in) == 0 {
 			return archsuffix
 		}
@@ -154,9 +180,9 @@ func getChrystalisPath(cfg *initfile.File, is64bit bool) string {
 
 func getChrystalisInitScript(cfg *initfile.File) string {
 	versiondir := getChrystalisVersionPath(cfg)
-	initScriptName := cfg.Section("chrysalis").Key("initscript").Validate(func(in string) string {
+	initScriptName := configKeyOf(cfg, "chrysalis", "initscript").Validate(func(in string) string {
 		if len(in) == 0 {
-			return "init.cmd"
+			return "init" + scriptExt
 		}
 		return in
 	})
@@ -164,7 +190,7 @@ func getChrystalisInitScript(cfg *initfile.File) string {
 }
 
 func getLarvaPath(cfg *initfile.File) string {
-	basedir := cfg.Section("larva").Key("appdir").Validate(func(in string) string {
+	basedir := configKeyOf(cfg, "larva", "appdir").Validate(func(in string) string {
 		if len(in) == 0 {
 			return "."
 		}
@@ -176,15 +202,34 @@ func getLarvaPath(cfg *initfile.File) string {
 
 func getLarvaStartupScript(cfg *initfile.File) string {
 	larvaPath := getLarvaPath(cfg)
-	initScriptName := cfg.Section("larva").Key("startup").Validate(func(in string) string {
+	initScriptName := configKeyOf(cfg, "larva", "startup").Validate(func(in string) string {
 		if len(in) == 0 {
-			return "larva.cmd"
+			return "larva" + scriptExt
 		}
 		return in
 	})
 	return GetAbsolutePath(filepath.Join(larvaPath, initScriptName))
 }
 
+func getSupervisor(cfg *initfile.File) Supervisor {
+	supervisor := DefaultSupervisor()
+
+	supervisor.Policy = configKeyOf(cfg, "supervisor", "restart").Validate(func(in string) string {
+		switch strings.ToLower(in) {
+		case RestartNever, RestartOnFailure, RestartAlways:
+			return strings.ToLower(in)
+		}
+		return supervisor.Policy
+	})
+	supervisor.MaxRestarts = configKeyOf(cfg, "supervisor", "restart.max").MustInt(supervisor.MaxRestarts)
+	supervisor.Window = configKeyOf(cfg, "supervisor", "restart.window").MustDuration(supervisor.Window)
+	supervisor.Delay = configKeyOf(cfg, "supervisor", "restart.delay").MustDuration(supervisor.Delay)
+	supervisor.MaxDelay = configKeyOf(cfg, "supervisor", "restart.delay.max").MustDuration(supervisor.MaxDelay)
+	supervisor.GracePeriod = configKeyOf(cfg, "supervisor", "shutdown.grace").MustDuration(supervisor.GracePeriod)
+	supervisor.NotifyTimeout = configKeyOf(cfg, "supervisor", "shutdown.notify").MustDuration(supervisor.NotifyTimeout)
+	return supervisor
+}
+
 // Cocoon configuration structure
 type Cocoon struct {
 	ArchStr           string
@@ -196,6 +241,7 @@ type Cocoon struct {
 	LarvaStartup      string `validate:"fileExists"`
 	UsePipe           bool
 	LogPath           string
+	Supervisor        Supervisor
 }
 
 func (cocoon Cocoon) String() string {
@@ -209,6 +255,7 @@ func (cocoon Cocoon) String() string {
 	buffer.WriteString(fmt.Sprintf("Larva path: %s\n", cocoon.LarvaPath))
 	buffer.WriteString(fmt.Sprintf("Larva startup script: %s\n", cocoon.LarvaStartup))
 	buffer.WriteString(fmt.Sprintf("Log path: %s\n", cocoon.LogPath))
+	buffer.WriteString(fmt.Sprintf("Supervisor: %v\n", cocoon.Supervisor))
 
 	return buffer.String()
 }
@@ -225,6 +272,7 @@ func NewCocoon(cfg *initfile.File, is64bit bool) Cocoon {
 		LarvaStartup:      getLarvaStartupScript(cfg),
 		UsePipe:           getCocoonUsepipe(cfg),
 		LogPath:           "",
+		Supervisor:        getSupervisor(cfg),
 	}
 }
 
@@ -240,6 +288,7 @@ func DefaultCocoon(startupCmdFile string, is64bit bool) Cocoon {
 		LarvaStartup:      startupCmdFile,
 		UsePipe:           false,
 		LogPath:           "",
+		Supervisor:        DefaultSupervisor(),
 	}
 }
 
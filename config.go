@@ -24,16 +24,23 @@
 package cocoon
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 
 	initfile "gopkg.in/ini.v1"
 )
 
+// configBackupSuffix is extension of previous config version, kept by saveConfig
+const configBackupSuffix = ".bak"
+
 // GetConfigFileName returns config file name
 func GetConfigFileName() string {
 	name, err := GetMyselfName()
@@ -50,7 +57,7 @@ func findRuntimeVersion(folder string) string {
 	}
 	dirs := make([]os.FileInfo, 0)
 	for _, v := range files {
-		if v.IsDir() {
+		if v.IsDir() && !isStagingDir(v.Name()) {
 			dirs = append(dirs, v)
 		}
 	}
@@ -79,7 +86,7 @@ func findRuntimeScript(defaultRuntimeFolder, defaultVersion, defaultName string)
 	scripts := make([]os.FileInfo, 0)
 	for _, v := range files {
 		ext := path.Ext(v.Name())
-		if strings.EqualFold(ext, ".cmd") {
+		if strings.EqualFold(ext, scriptExt) {
 			scripts = append(scripts, v)
 		}
 	}
@@ -91,7 +98,7 @@ func findRuntimeScript(defaultRuntimeFolder, defaultVersion, defaultName string)
 }
 
 func findLarvaScript() string {
-	return filepath.Base(GetCocoonAssetName(".cmd"))
+	return filepath.Base(GetCocoonAssetName(scriptExt))
 }
 
 func findLogFilename() string {
@@ -100,13 +107,15 @@ func findLogFilename() string {
 	return result
 }
 
-func createDefaultConfig(configFileName string) {
+func createDefaultConfig(configFileName string) error {
 	cfg := initfile.Empty()
 
-	cfg.Section("cocoon").Key("startup").SetValue("cocoon_init.cmd")
+	cfg.Section("cocoon").Key("startup").SetValue("cocoon_init" + scriptExt)
 	cfg.Section("cocoon").Key("log.file").SetValue(findLogFilename())
 	cfg.Section("cocoon").Key("log.level").SetValue("error")
+	cfg.Section("cocoon").Key("log.sink").SetValue(defaultLogSink)
 	cfg.Section("cocoon").Key("usepipe").SetValue("no")
+	cfg.Section("cocoon").Key("single.instance").SetValue(SingleInstanceOff)
 
 	defaultRuntimeFolder := "runtime"
 	defaultVersion := findRuntimeVersion(defaultRuntimeFolder)
@@ -115,18 +124,105 @@ func createDefaultConfig(configFileName string) {
 	cfg.Section("chrysalis").Key("dir.version").SetValue(defaultVersion)
 	cfg.Section("chrysalis").Key("dir.64bit").SetValue("x64")
 	cfg.Section("chrysalis").Key("dir.32bit").SetValue("i586")
-	cfg.Section("chrysalis").Key("initscript").SetValue(findRuntimeScript(defaultRuntimeFolder, defaultVersion, "init.cmd"))
+	cfg.Section("chrysalis").Key("initscript").SetValue(findRuntimeScript(defaultRuntimeFolder, defaultVersion, "init"+scriptExt))
 
 	cfg.Section("larva").Key("appdir").SetValue(".")
 	cfg.Section("larva").Key("startup").SetValue(findLarvaScript())
 
+	supervisor := DefaultSupervisor()
+	cfg.Section("supervisor").Key("restart").SetValue(supervisor.Policy)
+	cfg.Section("supervisor").Key("restart.max").SetValue(strconv.Itoa(supervisor.MaxRestarts))
+	cfg.Section("supervisor").Key("restart.window").SetValue(supervisor.Window.String())
+	cfg.Section("supervisor").Key("restart.delay").SetValue(supervisor.Delay.String())
+	cfg.Section("supervisor").Key("restart.delay.max").SetValue(supervisor.MaxDelay.String())
+	cfg.Section("supervisor").Key("shutdown.grace").SetValue(supervisor.GracePeriod.String())
+	cfg.Section("supervisor").Key("shutdown.notify").SetValue(supervisor.NotifyTimeout.String())
+
 	MetamorphoseDate(cfg)
 
-	err := cfg.SaveTo(configFileName)
+	return saveConfig(cfg, configFileName)
+}
+
+// createDefaultConfigLocked creates default config under config lock, unless another process created it while lock was awaited
+func createDefaultConfigLocked(configFileName string) error {
+	lock, err := acquireFileLock(configFileName+configLockSuffix, configLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	if _, err := os.Stat(configFileName); os.IsNotExist(err) {
+		return createDefaultConfig(configFileName)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data into temp file next to name, flushes it to disk and renames it over name
+func writeFileAtomic(name string, data []byte, mode os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	_ = tmp.Chmod(mode)
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := os.Rename(tmpName, name); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	syncDir(filepath.Dir(name))
+	return nil
+}
+
+// syncDir flushes folder entries to disk, so rename survives power loss. Not all platforms support it, so errors are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
 	if err != nil {
-		abort("createDefaultConfig", err)
+		return
 	}
+	_ = d.Sync()
+	_ = d.Close()
+}
 
+// parseConfig loads config from bytes. Empty content is error, because it is what truncated file looks like.
+func parseConfig(data []byte) (*initfile.File, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, errors.New("config file is empty")
+	}
+	return initfile.Load(data)
+}
+
+// saveConfig atomically replaces config file. Previous version is kept as backup, if it is valid.
+func saveConfig(cfg *initfile.File, configFileName string) error {
+	var buffer bytes.Buffer
+	if _, err := cfg.WriteTo(&buffer); err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(configFileName); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if data, err := ioutil.ReadFile(configFileName); err == nil {
+		if _, err := parseConfig(data); err == nil {
+			if err := writeFileAtomic(configFileName+configBackupSuffix, data, mode); err != nil {
+				return err
+			}
+		}
+	}
+	return writeFileAtomic(configFileName, buffer.Bytes(), mode)
 }
 
 // HasConfig checks is config file is available
@@ -145,8 +241,36 @@ func LoadConfig() (*initfile.File, error) {
 	configFileName := GetConfigFileName()
 
 	if _, err := os.Stat(configFileName); os.IsNotExist(err) {
-		createDefaultConfig(configFileName)
+		if err := createDefaultConfigLocked(configFileName); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := ioutil.ReadFile(configFileName)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := parseConfig(data)
+	if err == nil {
+		return cfg, nil
 	}
 
-	return initfile.Load(configFileName)
+	backupFileName := configFileName + configBackupSuffix
+	backupData, bakErr := ioutil.ReadFile(backupFileName)
+	if bakErr != nil {
+		return nil, err
+	}
+	backup, bakErr := parseConfig(backupData)
+	if bakErr != nil {
+		return nil, err
+	}
+	LogWarning(fmt.Sprintf("Config %v is broken (%v), restored from %v", configFileName, err, backupFileName))
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(configFileName); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if err := writeFileAtomic(configFileName, backupData, mode); err != nil {
+		LogError(err)
+	}
+	return backup, nil
 }
@@ -0,0 +1,105 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cocoon
+
+// Every known config key can be overridden by environment variable COCOON_CFG_<SECTION>_<KEY>,
+// dots and dashes in key name are replaced by underscores:
+//
+//	COCOON_CFG_COCOON_LOG_LEVEL=info
+//	COCOON_CFG_LARVA_STARTUP=debug.cmd
+//	COCOON_CFG_SUPERVISOR_RESTART_DELAY_MAX=1m
+//
+// Environment variable with empty value is ignored.
+//
+// Precedence is env > file > default. There is no flag layer at run time: cocoon command line is passed to larva as is,
+// so cocoon has no run-time flags of its own. Metamorphose command flags change file only, so commands warn
+// when changed key is shadowed by environment variable.
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	initfile "gopkg.in/ini.v1"
+)
+
+// configEnvPrefix starts names of environment variables, which override config keys
+const configEnvPrefix = "COCOON_CFG_"
+
+var configEnvReplacer = strings.NewReplacer(".", "_", "-", "_")
+
+// configEnvName returns name of environment variable, which overrides config key
+func configEnvName(section, key string) string {
+	return configEnvPrefix + strings.ToUpper(configEnvReplacer.Replace(section+"_"+key))
+}
+
+// lookupConfigEnv returns non-empty value of environment variable, which overrides config key
+func lookupConfigEnv(section, key string) (string, bool) {
+	value := os.Getenv(configEnvName(section, key))
+	return value, value != ""
+}
+
+// configKeyOf returns config key, or detached key with value of overriding environment variable
+func configKeyOf(cfg *initfile.File, section, key string) *initfile.Key {
+	if value, ok := lookupConfigEnv(section, key); ok {
+		if k, err := initfile.Empty().Section(section).NewKey(key, value); err == nil {
+			return k
+		}
+	}
+	return cfg.Section(section).Key(key)
+}
+
+// configValueOf returns effective value of known key and its source
+func configValueOf(cfg *initfile.File, key configKey) (string, string) {
+	if value, ok := lookupConfigEnv(key.Section, key.Key); ok {
+		return value, SourceEnv
+	}
+	if cfg.Section(key.Section).HasKey(key.Key) {
+		if value := cfg.Section(key.Section).Key(key.Key).String(); value != "" {
+			return value, SourceFile
+		}
+	}
+	return key.Default, SourceDefault
+}
+
+// logConfigSources writes effective value and source of every known key into log. Environment overrides are logged as warnings.
+func logConfigSources(cfg *initfile.File) {
+	for _, key := range configKeys {
+		value, source := configValueOf(cfg, key)
+		if source == SourceEnv {
+			LogWarning(fmt.Sprintf("Config %v = %v (%v %v)", key.Name(), value, source, configEnvName(key.Section, key.Key)))
+			continue
+		}
+		LogInfo(fmt.Sprintf("Config %v = %v (%v)", key.Name(), value, source))
+	}
+}
+
+// warnConfigEnvOverride tells user, who changes file value, that it is shadowed by environment variable at run time
+func warnConfigEnvOverride(key configKey) {
+	name := configEnvName(key.Section, key.Key)
+	if _, ok := lookupConfigEnv(key.Section, key.Key); ok {
+		LogWarning(fmt.Sprintf("Config %v is overridden by %v", key.Name(), name))
+		_, _ = fmt.Fprintf(os.Stderr, "metamorphose: %v is overridden by %v at run time\n", key.Name(), name)
+	}
+}
@@ -0,0 +1,164 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cocoon
+
+// cocoon.exe metamorphose get larva.startup
+// cocoon.exe metamorphose set supervisor.restart on-failure
+// cocoon.exe metamorphose unset supervisor.restart
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	initfile "gopkg.in/ini.v1"
+)
+
+// Types of configuration values
+const (
+	TypeString   = "string"
+	TypeBool     = "bool"
+	TypeInt      = "int"
+	TypeDuration = "duration"
+)
+
+// configKey describes known configuration key
+type configKey struct {
+	Section string
+	Key     string
+	Type    string
+	Default string
+	Allowed []string
+}
+
+// Name returns key name in 'section.key' form
+func (k configKey) Name() string {
+	return k.Section + "." + k.Key
+}
+
+var boolValues = []string{"yes", "no", "true", "false"}
+
+// configKeys is schema of known configuration keys with their default values
+var configKeys = []configKey{
+	{"cocoon", "startup", TypeString, "cocoon_init" + scriptExt, nil},
+	{"cocoon", "log.file", TypeString, "CocoonProcess", nil},
+	{"cocoon", "log.level", TypeString, "error", []string{"info", "warning", "error"}},
+	{"cocoon", "log.sink", TypeString, defaultLogSink, []string{LogSinkEventlog, LogSinkFile, LogSinkStderr, LogSinkMemory}},
+	{"cocoon", "usepipe", TypeBool, "false", boolValues},
+	{"cocoon", "single.instance", TypeString, SingleInstanceOff, []string{SingleInstanceOff, SingleInstanceExit, SingleInstanceForward}},
+	{"chrysalis", "dir.base", TypeString, "runtime", nil},
+	{"chrysalis", "dir.version", TypeString, "", nil},
+	{"chrysalis", "dir.64bit", TypeString, "64bit", nil},
+	{"chrysalis", "dir.32bit", TypeString, "32bit", nil},
+	{"chrysalis", "initscript", TypeString, "init" + scriptExt, nil},
+	{"larva", "appdir", TypeString, ".", nil},
+	{"larva", "startup", TypeString, "larva" + scriptExt, nil},
+	{"supervisor", "restart", TypeString, DefaultSupervisor().Policy, []string{RestartNever, RestartOnFailure, RestartAlways}},
+	{"supervisor", "restart.max", TypeInt, strconv.Itoa(DefaultSupervisor().MaxRestarts), nil},
+	{"supervisor", "restart.window", TypeDuration, DefaultSupervisor().Window.String(), nil},
+	{"supervisor", "restart.delay", TypeDuration, DefaultSupervisor().Delay.String(), nil},
+	{"supervisor", "restart.delay.max", TypeDuration, DefaultSupervisor().MaxDelay.String(), nil},
+	{"supervisor", "shutdown.grace", TypeDuration, DefaultSupervisor().GracePeriod.String(), nil},
+	{"supervisor", "shutdown.notify", TypeDuration, DefaultSupervisor().NotifyTimeout.String(), nil},
+}
+
+// findConfigKey looks for known key by 'section.key' name
+func findConfigKey(name string) (configKey, error) {
+	for _, k := range configKeys {
+		if strings.EqualFold(k.Name(), name) {
+			return k, nil
+		}
+	}
+	return configKey{}, fmt.Errorf("unknown config key %q", name)
+}
+
+// validate checks value against key type and allowed values. Returns value in canonical case.
+func (k configKey) validate(value string) (string, error) {
+	switch k.Type {
+	case TypeInt:
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			return "", fmt.Errorf("%v: %q is not a non-negative integer", k.Name(), value)
+		}
+	case TypeDuration:
+		if d, err := time.ParseDuration(value); err != nil || d < 0 {
+			return "", fmt.Errorf("%v: %q is not a duration", k.Name(), value)
+		}
+	}
+	if len(k.Allowed) == 0 {
+		return value, nil
+	}
+	for _, v := range k.Allowed {
+		if strings.EqualFold(v, value) {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("%v: %q is not one of %v", k.Name(), value, strings.Join(k.Allowed, ", "))
+}
+
+// MetamorphoseGet prints effective value of known config key: environment override, file value or default value
+func MetamorphoseGet(name string, cfg *initfile.File, w io.Writer) bool {
+	key, err := findConfigKey(name)
+	if err != nil {
+		reportMorphError(err)
+		return false
+	}
+	value, _ := configValueOf(cfg, key)
+	fmt.Fprintln(w, value)
+	return true
+}
+
+// MetamorphoseSet validates and sets value of known config key
+func MetamorphoseSet(name, value string, cfg *initfile.File) bool {
+	key, err := findConfigKey(name)
+	if err != nil {
+		reportMorphError(err)
+		return false
+	}
+	value, err = key.validate(value)
+	if err != nil {
+		reportMorphError(err)
+		return false
+	}
+	if key.Section == "chrysalis" && key.Key == "dir.version" {
+		// switching chrysalis also updates digest and history
+		return MetamorphoseChrysalisDir(value, cfg)
+	}
+	cfg.Section(key.Section).Key(key.Key).SetValue(value)
+	logMorphInfo(key.Section, key.Key, value)
+	return true
+}
+
+// MetamorphoseUnset removes known config key, so default value is used
+func MetamorphoseUnset(name string, cfg *initfile.File) bool {
+	key, err := findConfigKey(name)
+	if err != nil {
+		reportMorphError(err)
+		return false
+	}
+	cfg.Section(key.Section).DeleteKey(key.Key)
+	logMorphInfo(key.Section, key.Key, "<default>")
+	return true
+}
@@ -0,0 +1,189 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cocoon
+
+// Larva environment is extended by [environment] config section and by .env file in larva folder:
+//
+//	[environment]
+//	APP_DATA     = ${COCOON_APPDIR}/data
+//	PATH.prepend = ${COCOON_RUNTIME}/bin
+//	PATH.append  = ${COCOON_APPDIR}/tools
+//
+// Values are expanded against larva environment, built so far (COCOON_* values and previous entries),
+// and then against whole parent environment, so ${JAVA_HOME} and other variables, which are not
+// inherited by larva, are still expanded. '$$' is literal '$'. '<NAME>.prepend' and '<NAME>.append'
+// add value to existing variable with path list separator. .env entries are applied after config entries.
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	initfile "gopkg.in/ini.v1"
+)
+
+// dotEnvFile is name of optional environment file in larva folder
+const dotEnvFile = ".env"
+
+// Suffixes of environment entries, which add value to existing variable
+const (
+	envPrependSuffix = ".prepend"
+	envAppendSuffix  = ".append"
+)
+
+// envEntry is one environment entry of config section or .env file
+type envEntry struct {
+	Name  string
+	Value string
+}
+
+func envNameEqual(a, b string) bool {
+	if envCaseInsensitive {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// lookupEnv finds variable value in 'NAME=value' list
+func lookupEnv(env []string, name string) (string, bool) {
+	for _, v := range env {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) == 2 && envNameEqual(kv[0], name) {
+			return kv[1], true
+		}
+	}
+	return "", false
+}
+
+// setEnv replaces variable in 'NAME=value' list or appends new one
+func setEnv(env []string, name, value string) []string {
+	for i, v := range env {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) == 2 && envNameEqual(kv[0], name) {
+			// keep original name case, e.g. 'Path' on windows
+			env[i] = kv[0] + "=" + value
+			return env
+		}
+	}
+	return append(env, name+"="+value)
+}
+
+// expandEnv expands ${VAR} and $VAR references against env, then against parent environment
+func expandEnv(value string, env []string) string {
+	return os.Expand(value, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		if v, ok := lookupEnv(env, name); ok {
+			return v
+		}
+		return os.Getenv(name)
+	})
+}
+
+// applyEnvEntry expands entry value and sets, prepends or appends it
+func applyEnvEntry(env []string, entry envEntry) []string {
+	name := entry.Name
+	value := expandEnv(entry.Value, env)
+	lowerName := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lowerName, envPrependSuffix):
+		name = name[:len(name)-len(envPrependSuffix)]
+		if current, ok := lookupEnv(env, name); ok && current != "" {
+			value = value + string(os.PathListSeparator) + current
+		}
+	case strings.HasSuffix(lowerName, envAppendSuffix):
+		name = name[:len(name)-len(envAppendSuffix)]
+		if current, ok := lookupEnv(env, name); ok && current != "" {
+			value = current + string(os.PathListSeparator) + value
+		}
+	}
+	// values are not logged, .env files usually hold credentials
+	LogInfo("Larva environment: " + name)
+	return setEnv(env, name, value)
+}
+
+// configEnvEntries returns entries of [environment] config section in file order
+func configEnvEntries(cfg *initfile.File) []envEntry {
+	entries := make([]envEntry, 0)
+	if cfg == nil {
+		return entries
+	}
+	section, err := cfg.GetSection("environment")
+	if err != nil {
+		return entries
+	}
+	for _, key := range section.Keys() {
+		entries = append(entries, envEntry{Name: key.Name(), Value: key.Value()})
+	}
+	return entries
+}
+
+// readDotEnv parses 'NAME=value' lines of .env file. '#' starts comment line, 'export ' prefix and quotes around value are dropped.
+// Missing file is not an error.
+func readDotEnv(name string) ([]envEntry, error) {
+	entries := make([]envEntry, 0)
+	file, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+		kv := strings.SplitN(text, "=", 2)
+		entryName := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || entryName == "" {
+			return nil, fmt.Errorf("%v:%d: expected NAME=value", name, line)
+		}
+		value := strings.TrimSpace(kv[1])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		entries = append(entries, envEntry{Name: entryName, Value: value})
+	}
+	return entries, scanner.Err()
+}
+
+// larvaEnviron applies [environment] config section and .env file of larva folder to env
+func larvaEnviron(env []string, cfg *initfile.File, larvaPath string) ([]string, error) {
+	dotEnv, err := readDotEnv(filepath.Join(larvaPath, dotEnvFile))
+	if err != nil {
+		return env, err
+	}
+	for _, entry := range append(configEnvEntries(cfg), dotEnv...) {
+		env = applyEnvEntry(env, entry)
+	}
+	return env, nil
+}
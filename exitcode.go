@@ -0,0 +1,78 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cocoon
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Exit codes of cocoon failures. Codes 240-249 are reserved for cocoon itself, larva exit code is passed as is.
+const (
+	ExitSuccess         = 0
+	ExitMorphError      = 1   // metamorphose command failed
+	ExitConfigError     = 240 // config file can not be created or read
+	ExitValidationError = 241 // cocoon paths validation failed
+	ExitOutputsError    = 242 // stdout/stderr redirection failed
+	ExitLaunchError     = 243 // larva scripts can not be started or waited
+	ExitAlreadyRunning  = 244 // another cocoon of the same install is running
+	ExitInstanceError   = 245 // single instance lock file can not be created or locked
+)
+
+// ExitError is cocoon failure with process exit code
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("%v (exit code %v)", e.Err, e.Code)
+}
+
+func newExitError(code int, err error) *ExitError {
+	return &ExitError{Code: code, Err: err}
+}
+
+// exitCodeOf returns larva process exit code. Signal termination is reported as 128+signal, like shells do.
+func exitCodeOf(state *os.ProcessState) int {
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return state.ExitCode()
+}
+
+// Run starts cocoon container and exits process with larva exit code, or with cocoon failure code.
+func Run(startupCmdFile, logFileName string, cocoon *Cocoon) {
+	code, _ := Start(startupCmdFile, logFileName, cocoon)
+	os.Exit(code)
+}
+
+// closeLogWith logs cocoon failure and closes log. Failure is logged first, while log sink is still open.
+func closeLogWith(err *error) {
+	if *err != nil {
+		LogError(*err)
+	}
+	CloseLog()
+}
@@ -0,0 +1,97 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cocoon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	initfile "gopkg.in/ini.v1"
+)
+
+// configLockSuffix is extension of advisory lock file, created next to config file
+const configLockSuffix = ".lock"
+
+const (
+	configLockTimeout = 30 * time.Second
+	lockRetryInterval = 100 * time.Millisecond
+)
+
+// ErrLocked is returned when lock is held by another process longer than timeout
+var ErrLocked = errors.New("locked by another process")
+
+// fileLock is advisory inter-process lock, held on open file
+type fileLock struct {
+	file *os.File
+}
+
+// acquireFileLock creates lock file and waits until exclusive lock is acquired or timeout is expired
+func acquireFileLock(name string, timeout time.Duration) (*fileLock, error) {
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLockFile(file)
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		if locked {
+			return &fileLock{file: file}, nil
+		}
+		if time.Now().After(deadline) {
+			_ = file.Close()
+			return nil, fmt.Errorf("%v: %w, waited %v", name, ErrLocked, timeout)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// Release unlocks and closes lock file. Lock file itself is kept, so other processes always lock the same file.
+func (l *fileLock) Release() {
+	if l == nil || l.file == nil {
+		return
+	}
+	_ = unlockFile(l.file)
+	_ = l.file.Close()
+	l.file = nil
+}
+
+// lockConfig acquires config lock and reloads config, so changes are applied to its latest version
+func lockConfig() (*fileLock, *initfile.File, error) {
+	lock, err := acquireFileLock(GetConfigFileName()+configLockSuffix, configLockTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, err := LoadConfig()
+	if err != nil {
+		lock.Release()
+		return nil, nil, err
+	}
+	return lock, cfg, nil
+}
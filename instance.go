@@ -0,0 +1,168 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cocoon
+
+// [cocoon]
+// single.instance = forward
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Single instance modes
+const (
+	SingleInstanceOff     = "no"
+	SingleInstanceExit    = "exit"
+	SingleInstanceForward = "forward"
+)
+
+// instanceLockSuffix is extension of per-install lock file, held by running cocoon
+const instanceLockSuffix = ".instance.lock"
+
+// forwardTimeout limits wait for connected larva, which receives forwarded arguments
+const forwardTimeout = 3 * time.Second
+
+// ErrAlreadyRunning is returned when another cocoon of the same install is running
+var ErrAlreadyRunning = errors.New("cocoon is already running")
+
+// instanceLockName returns lock file in per-user folder, named by hash of cocoon executable path, so each install has its own lock
+func instanceLockName() (string, error) {
+	dir, err := getInstanceDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(instanceKey(GetCocoonAssetName(""))))
+	return filepath.Join(dir, fmt.Sprintf("cocoon_%x%v", sum[:8], instanceLockSuffix)), nil
+}
+
+// acquireInstanceLock takes per-install lock without waiting
+func acquireInstanceLock() (*fileLock, error) {
+	name, err := instanceLockName()
+	if err != nil {
+		return nil, err
+	}
+	lock, err := acquireFileLock(name, 0)
+	if errors.Is(err, ErrLocked) {
+		return nil, ErrAlreadyRunning
+	}
+	if err != nil {
+		return nil, err
+	}
+	// address of previous run is dropped, it may point to endpoint of unrelated process with the same pid
+	if err := lock.file.Truncate(0); err != nil {
+		lock.Release()
+		return nil, err
+	}
+	return lock, nil
+}
+
+// publishInstanceAddress writes transport address into instance lock file, so next launch can forward its arguments
+func publishInstanceAddress(lock *fileLock, address string) error {
+	if err := lock.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := lock.file.WriteAt([]byte(address), 0)
+	return err
+}
+
+// forwardArguments sends command line arguments to running instance, which relays them to larva as 'args' event
+func forwardArguments(args []string) error {
+	name, err := instanceLockName()
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	address := strings.TrimSpace(string(data))
+	if address == "" {
+		return errors.New("running cocoon does not use pipe")
+	}
+
+	conn, err := dialTransport(address, forwardTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// running cocoon replies after larva receives arguments, or after forwardTimeout
+	_ = conn.SetDeadline(time.Now().Add(2 * forwardTimeout))
+
+	payload, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	request := Message{Version: ProtocolVersion, Type: MessageRequest, ID: "forward", Command: "forward", Payload: payload}
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return err
+	}
+
+	// skip events, which running cocoon may send on connect
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), maxMessageSize)
+	for scanner.Scan() {
+		var reply Message
+		if err := json.Unmarshal(scanner.Bytes(), &reply); err != nil {
+			return err
+		}
+		switch {
+		case reply.ID != request.ID:
+			continue
+		case reply.Type == MessageError:
+			return errors.New(reply.Error)
+		default:
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("running cocoon closed connection without reply")
+}
+
+// forwardHandler relays arguments of second launch to connected larva sessions as 'args' event.
+// It replies only after at least one larva received them.
+func forwardHandler(message Message) (interface{}, error) {
+	var args []string
+	if err := message.DecodePayload(&args); err != nil {
+		return nil, err
+	}
+	event, err := NewEvent("args", args)
+	if err != nil {
+		return nil, err
+	}
+	LogInfo(fmt.Sprintf("Forward arguments to larva: %v", args))
+	if sendToSessions(event, forwardTimeout) == 0 {
+		return nil, fmt.Errorf("arguments are not delivered: no larva is connected in %v", forwardTimeout)
+	}
+	return nil, nil
+}
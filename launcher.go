@@ -0,0 +1,47 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cocoon
+
+import "os"
+
+// Launcher starts scripts sequence, prepared by appendScript, as one larva process.
+type Launcher interface {
+	// Launch executes scripts sequence with given process attributes. Returns nil on fail.
+	Launch(scriptNames []string, attr *os.ProcAttr) *os.Process
+}
+
+var launcher Launcher = newPlatformLauncher()
+
+// GetLauncher returns current platform launcher
+func GetLauncher() Launcher {
+	return launcher
+}
+
+// SetLauncher replaces platform launcher. Nil value restores the default one.
+func SetLauncher(l Launcher) {
+	if l == nil {
+		l = newPlatformLauncher()
+	}
+	launcher = l
+}
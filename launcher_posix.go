@@ -0,0 +1,100 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+//go:build !windows
+// +build !windows
+
+package cocoon
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// scriptExt is default extension of cocoon, chrysalis and larva scripts
+const scriptExt = ".sh"
+
+// envCaseInsensitive is true if environment variable names are case insensitive
+const envCaseInsensitive = false
+
+// shellPath is POSIX shell used to run scripts sequence
+const shellPath = "/bin/sh"
+
+// shLauncher runs scripts sequence with /bin/sh
+type shLauncher struct{}
+
+func newPlatformLauncher() Launcher {
+	return shLauncher{}
+}
+
+// Launch implements Launcher with StartShScripts. Larva descendants stay in larva process group.
+func (shLauncher) Launch(scriptNames []string, attr *os.ProcAttr) *os.Process {
+	becomeSubreaper()
+	return StartShScripts(scriptNames, attr)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func makeShLine(args []string) string {
+	parts := make([]string, 0, len(args))
+	for _, v := range args {
+		if v == "&&" {
+			parts = append(parts, v)
+			continue
+		}
+		// scripts are sourced, so variables exported by init scripts reach larva, as with cmd.exe
+		parts = append(parts, ". "+shellQuote(v))
+	}
+	return strings.Join(parts, " ")
+}
+
+// StartShScripts creates sequence '. "script1" && . "script2" && ...' and call /bin/sh with this sequence as -c value
+func StartShScripts(scriptNames []string, attr *os.ProcAttr) *os.Process {
+	if attr.Sys == nil {
+		attr.Sys = &syscall.SysProcAttr{}
+	}
+	attr.Sys.Setpgid = true
+	attr.Sys.Foreground = false
+	if len(attr.Files) > 0 && isForegroundTTY(attr.Files[0]) {
+		// larva in background process group is stopped by SIGTTIN on terminal read, so larva group takes terminal
+		attr.Sys.Foreground = true
+		attr.Sys.Ctty = int(attr.Files[0].Fd())
+		foregroundTTY = attr.Sys.Ctty
+	}
+
+	shLine := makeShLine(scriptNames)
+
+	LogInfo(fmt.Sprintf("execute scripts touple: %v", shLine))
+	p, perr := os.StartProcess(shellPath, []string{shellPath, "-c", shLine}, attr)
+	if perr != nil {
+		LogError(perr)
+	} else {
+		return p
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+//go:build windows
+// +build windows
+
+package cocoon
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// scriptExt is default extension of cocoon, chrysalis and larva scripts
+const scriptExt = ".cmd"
+
+// envCaseInsensitive is true if environment variable names are case insensitive
+const envCaseInsensitive = true
+
+// cmdLauncher runs scripts sequence with %COMSPEC% (cmd.exe expected)
+type cmdLauncher struct{}
+
+func newPlatformLauncher() Launcher {
+	return cmdLauncher{}
+}
+
+// Launch implements Launcher with StartCmdScripts. cmd.exe is started suspended and resumed after it is attached to Job Object,
+// so it can not start children outside of job.
+func (cmdLauncher) Launch(scriptNames []string, attr *os.ProcAttr) *os.Process {
+	if attr.Sys == nil {
+		attr.Sys = &syscall.SysProcAttr{}
+	}
+	attr.Sys.CreationFlags |= windows.CREATE_SUSPENDED
+	p := StartCmdScripts(scriptNames, attr)
+	if p == nil {
+		return nil
+	}
+	if err := attachLarvaJob(p); err != nil {
+		LogWarning(fmt.Sprintf("Larva process %v is not attached to job: %v", p.Pid, err))
+	}
+	if err := resumeProcess(p); err != nil {
+		LogError(fmt.Sprintf("Larva process %v can not be resumed: %v", p.Pid, err))
+		_ = p.Kill()
+		_, _ = p.Wait()
+		return nil
+	}
+	return p
+}
@@ -0,0 +1,140 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cocoon
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	lumberjack "gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Log sink names, accepted by [cocoon].log.sink config value
+const (
+	LogSinkEventlog = "eventlog"
+	LogSinkFile     = "file"
+	LogSinkStderr   = "stderr"
+	LogSinkMemory   = "memory"
+)
+
+// LogSink is a destination of cocoon log records
+type LogSink interface {
+	Write(level Severity, text string) error
+	Close() error
+}
+
+// NewLogSink creates log sink by name. Title is an event source name or log file name prefix.
+func NewLogSink(name, title string) (LogSink, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return NewLogSink(defaultLogSink, title)
+	case LogSinkEventlog:
+		return newEventlogSink(title)
+	case LogSinkFile:
+		return newFileSink(title), nil
+	case LogSinkStderr:
+		return newStderrSink(title), nil
+	case LogSinkMemory:
+		return &MemorySink{}, nil
+	}
+	return nil, fmt.Errorf("unknown log sink: %v", name)
+}
+
+// fileSink writes records into rotating <title>.log file in cocoon logs folder
+type fileSink struct {
+	rotator *lumberjack.Logger
+	logger  *log.Logger
+}
+
+func newFileSink(title string) *fileSink {
+	rotator := &lumberjack.Logger{
+		Filename:   filepath.Join(getLogsFolder(GetMyselfDir()), title+".log"),
+		MaxSize:    1,  // megabytes after which new file is created
+		MaxBackups: 3,  // number of backups
+		MaxAge:     28, //days
+		Compress:   true,
+	}
+	return &fileSink{rotator: rotator, logger: log.New(rotator, "", log.Ldate|log.Ltime)}
+}
+
+func (s *fileSink) Write(level Severity, text string) error {
+	s.logger.Println(level.String() + " " + text)
+	return nil
+}
+
+func (s *fileSink) Close() error {
+	return s.rotator.Close()
+}
+
+// stderrSink writes records into stderr with syslog priority prefixes, understood by journald and syslog collectors
+type stderrSink struct {
+	out *os.File
+}
+
+func newStderrSink(title string) *stderrSink {
+	return &stderrSink{out: os.Stderr}
+}
+
+func (s *stderrSink) Write(level Severity, text string) error {
+	_, err := fmt.Fprintf(s.out, "<%d>%s\n", level.syslogPriority(), text)
+	return err
+}
+
+func (s *stderrSink) Close() error {
+	return nil
+}
+
+// MemorySink keeps log records in memory. Useful for tests.
+type MemorySink struct {
+	mutex   sync.Mutex
+	records []prependedRecord
+}
+
+// Write appends record
+func (s *MemorySink) Write(level Severity, text string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.records = append(s.records, prependedRecord{Level: level, Text: text})
+	return nil
+}
+
+// Close does nothing, records are kept
+func (s *MemorySink) Close() error {
+	return nil
+}
+
+// Lines returns written records as '<severity> <text>' strings
+func (s *MemorySink) Lines() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	lines := make([]string, 0, len(s.records))
+	for _, record := range s.records {
+		lines = append(lines, record.Level.String()+" "+record.Text)
+	}
+	return lines
+}
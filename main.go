@@ -25,12 +25,13 @@ package cocoon
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"syscall"
 
-	"github.com/natefinch/npipe"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 	initfile "gopkg.in/ini.v1"
 	validator "gopkg.in/validator.v2"
@@ -43,15 +44,41 @@ var (
 	cocoonStartup  = morphCommand.Flag("cocoon-startup", "Set cocoon preparation script name").String()
 	cocoonLoglevel = morphCommand.Flag("cocoon-loglevel", "Set cocoon log level").Enum("info", "warning", "error")
 	cocoonLogname  = morphCommand.Flag("cocoon-logname", "Set cocoon application name").String()
+	cocoonLogsink  = morphCommand.Flag("cocoon-logsink", "Set cocoon log sink").Enum(LogSinkEventlog, LogSinkFile, LogSinkStderr, LogSinkMemory)
 	cocoonUsepipe  = morphCommand.Flag("cocoon-usepipe", "Use pipe for communication from larva to cocoon").Enum("yes", "no", "true", "false")
 	chrysalisDir   = morphCommand.Flag("chrysalis-dir", "Set chrysalis dir name").String()
 	larvaStartup   = morphCommand.Flag("larva-startup", "Set larva run script").String()
+	larvaRestart   = morphCommand.Flag("supervisor-restart", "Set larva restart policy").Enum(RestartNever, RestartOnFailure, RestartAlways)
 	injectCommand  = metamorphose.Command("inject", "Inject new chrysalis into cocoon")
 	injectName     = injectCommand.Arg("name", "New chrysalis name").Required().String()
-	injectZip      = injectCommand.Arg("injected", "ZIP file with new chrysalis").Required().String()
+	injectZip      = injectCommand.Arg("injected", "Archive (zip, tar, tar.gz, tar.xz, tar.zst) with new chrysalis").Required().String()
 	dropRuntimes   = injectCommand.Arg("dropOther", "Delete old chrysalises on success").Enum("yes", "no", "true", "false")
-
-	larvaProcess *os.Process
+	injectSHA256   = injectCommand.Flag("sha256", "Expected archive SHA-256, <archive>.sha256 file is used if omitted").String()
+	injectStrip    = injectCommand.Flag("strip-components", "Strip N leading path components, or 'auto' to strip single wrapping folder").String()
+	keygenCommand  = metamorphose.Command("keygen", "Generate Ed25519 key pair for chrysalis signing")
+	keygenFile     = keygenCommand.Arg("keyfile", "Private key file, public key is written into <keyfile>.pub").Required().String()
+	signCommand    = metamorphose.Command("sign", "Sign chrysalis archive, signature is written into <archive>.sig")
+	signKeyFile    = signCommand.Arg("keyfile", "Private key file").Required().String()
+	signArchive    = signCommand.Arg("archive", "Chrysalis archive").Required().String()
+	removeCommand  = metamorphose.Command("remove", "Delete chrysalis")
+	removeName     = removeCommand.Arg("name", "Chrysalis name").String()
+	removeKeep     = removeCommand.Flag("keep", "Delete all chrysalises except N newest").Int()
+	removeForce    = removeCommand.Flag("force", "Delete active chrysalis too").Bool()
+	verifyCommand  = metamorphose.Command("verify", "Compare chrysalis files with manifest, recorded at inject time")
+	verifyName     = verifyCommand.Arg("name", "Chrysalis name, active one by default").String()
+	showCommand    = metamorphose.Command("show", "Show effective configuration")
+	showFormat     = showCommand.Flag("format", "Output format").Default(FormatText).Enum(FormatText, FormatJSON)
+	getCommand     = metamorphose.Command("get", "Print config value")
+	getKey         = getCommand.Arg("key", "Config key as section.key").Required().String()
+	setCommand     = metamorphose.Command("set", "Set config value")
+	setKey         = setCommand.Arg("key", "Config key as section.key").Required().String()
+	setValue       = setCommand.Arg("value", "New value").Required().String()
+	unsetCommand   = metamorphose.Command("unset", "Remove config value, so default is used")
+	unsetKey       = unsetCommand.Arg("key", "Config key as section.key").Required().String()
+	listCommand    = metamorphose.Command("list", "List installed chrysalises")
+	listFormat     = listCommand.Flag("format", "Output format").Default(FormatText).Enum(FormatText, FormatJSON)
+	rollbackCmd    = metamorphose.Command("rollback", "Reactivate previous chrysalis")
+	rollbackSteps  = rollbackCmd.Arg("steps", "How many activations back").Default("1").Int()
 
 	cocoonEnvPrefix = []string{
 		"COCOON_",
@@ -64,6 +91,16 @@ var (
 		"CURRENT_RUNTIME",
 		"_ROOT",
 	}
+
+	// mutatingCommands change config or chrysalis folders, so they run under config lock
+	mutatingCommands = map[string]bool{
+		"metamorphose morph":    true,
+		"metamorphose inject":   true,
+		"metamorphose rollback": true,
+		"metamorphose remove":   true,
+		"metamorphose set":      true,
+		"metamorphose unset":    true,
+	}
 )
 
 func appendScript(scriptName string, slice []string) []string {
@@ -76,43 +113,93 @@ func appendScript(scriptName string, slice []string) []string {
 	return append(slice, "&&", scriptName)
 }
 
-func readConfiguration() *initfile.File {
+// readConfiguration loads config and executes metamorphose command, if any. Done is true if command is executed and cocoon must exit.
+func readConfiguration() (cfg *initfile.File, done bool, err error) {
 	myName, _ := GetMyselfName()
-	cfg, err := LoadConfig()
+	cfg, err = LoadConfig()
 	if err != nil {
 		showError(fmt.Sprintf("Fail to read file: %v", err))
-		os.Exit(1)
+		return nil, false, newExitError(ExitConfigError, err)
 	}
 	params := os.Args[1:]
-	exitFlag := 0
+	var morphErr error
 
 	if ShouldMetamorph(params) {
 		appCommand, cmdErr := application.Parse(params)
 		LogWarning("Metamorphose: " + appCommand)
 		if cmdErr == nil {
 			var cfgChanged = false
+			var cmdDone = false
+			var cmdFailed = false
+			var configLock *fileLock
+			if mutatingCommands[appCommand] {
+				var lockErr error
+				if configLock, cfg, lockErr = lockConfig(); lockErr != nil {
+					reportMorphError(lockErr)
+					return nil, false, newExitError(ExitConfigError, lockErr)
+				}
+			}
 			switch appCommand {
 			case "metamorphose morph":
 
 				cfgChanged = cfgChanged || MetamorphoseCocoonStartup(*cocoonStartup, cfg)
 				cfgChanged = cfgChanged || MetamorphoseCocoonLoglevel(*cocoonLoglevel, cfg)
 				cfgChanged = cfgChanged || MetamorphoseCocoonLogname(*cocoonLogname, cfg)
+				cfgChanged = cfgChanged || MetamorphoseCocoonLogsink(*cocoonLogsink, cfg)
 				cfgChanged = cfgChanged || MetamorphoseCocoonUsepipe(*cocoonUsepipe, cfg)
 				cfgChanged = cfgChanged || MetamorphoseChrysalisDir(*chrysalisDir, cfg)
 				cfgChanged = cfgChanged || MetamorphoseLarvaStartup(*larvaStartup, cfg)
+				cfgChanged = cfgChanged || MetamorphoseSupervisorRestart(*larvaRestart, cfg)
 				break
 			case "metamorphose inject":
-				cfgChanged = cfgChanged || MetamorphoseInjectChrysalis(*injectName, *injectZip, *dropRuntimes, cfg)
+				cfgChanged = cfgChanged || MetamorphoseInjectChrysalis(*injectName, *injectZip, *dropRuntimes, *injectStrip, *injectSHA256, cfg)
+				break
+			case "metamorphose rollback":
+				cfgChanged = cfgChanged || MetamorphoseRollback(*rollbackSteps, cfg)
+				break
+			case "metamorphose remove":
+				// partly failed remove changes config too, so config is saved and failure is reported by exit code
+				var removed bool
+				removed, cmdDone = MetamorphoseRemove(*removeName, *removeKeep, *removeForce, cfg)
+				cmdFailed = !cmdDone
+				cfgChanged = cfgChanged || removed
+				break
+			case "metamorphose set":
+				cfgChanged = cfgChanged || MetamorphoseSet(*setKey, *setValue, cfg)
+				break
+			case "metamorphose unset":
+				cfgChanged = cfgChanged || MetamorphoseUnset(*unsetKey, cfg)
+				break
+			case "metamorphose get":
+				cmdDone = MetamorphoseGet(*getKey, cfg, os.Stdout)
+				break
+			case "metamorphose keygen":
+				cmdDone = MetamorphoseKeygen(*keygenFile, os.Stdout)
+				break
+			case "metamorphose sign":
+				cmdDone = MetamorphoseSign(*signKeyFile, *signArchive, os.Stdout)
+				break
+			case "metamorphose verify":
+				cmdDone = MetamorphoseVerify(*verifyName, cfg, os.Stdout)
+				break
+			case "metamorphose show":
+				cmdDone = MetamorphoseShow(*showFormat, os.Stdout)
+				break
+			case "metamorphose list":
+				cmdDone = MetamorphoseList(*listFormat, cfg, os.Stdout)
 				break
 			}
 			if cfgChanged {
 				MetamorphoseDate(cfg)
-				_ = cfg.SaveTo(GetConfigFileName())
-				exitFlag = 1
-			} else {
-				LogError(fmt.Sprintf("Metamorphose: %v failed\n %v", appCommand, os.Args))
-				exitFlag = 2
+				if err := saveConfig(cfg, GetConfigFileName()); err != nil {
+					morphErr = newExitError(ExitMorphError, err)
+				}
+			}
+			if morphErr == nil && (cmdFailed || (!cfgChanged && !cmdDone)) {
+				morphErr = newExitError(ExitMorphError, fmt.Errorf("%v failed: %v", appCommand, os.Args))
 			}
+			done = true
+			configLock.Release()
 		} else {
 			kingpin.Usage()
 		}
@@ -120,25 +207,14 @@ func readConfiguration() *initfile.File {
 
 	logFileName := getCocoonLogFilename(cfg)
 	logLevel := getCocoonLogLevel(cfg)
+	logSinkName := getCocoonLogSink(cfg)
 
-	Initlog(logFileName, myName)
+	InitlogSink(logSinkName, logFileName, myName)
 	SetLogLevel(ParseLogLevel(logLevel))
 
-	if exitFlag > 0 {
-		os.Exit(exitFlag - 1)
-	}
-	return cfg
-}
+	cleanupStaging(cfg)
 
-func Stop() {
-	if larvaProcess == nil {
-		return
-	}
-	notifyChilds("event", "die")
-	if larvaProcess != nil {
-		larvaProcess.Kill()
-	}
-	larvaProcess = nil
+	return cfg, done, morphErr
 }
 
 func isCocoonEnv(envstring string) bool {
@@ -162,9 +238,10 @@ func filterOutEnviron(orig []string) []string {
 	return filtered
 }
 
-// Start cocoon container
-func Start(startupCmdFile, logFileName string, cocoon *Cocoon) {
+// Start cocoon container. Returns larva exit code, or cocoon failure code with error.
+func Start(startupCmdFile, logFileName string, cocoon *Cocoon) (code int, err error) {
 	Stop()
+	atomic.StoreInt32(&supervisorStopped, 0)
 	isConsoleAttached := AttachConsole()
 
 	defer FreeConsole()
@@ -177,12 +254,56 @@ func Start(startupCmdFile, logFileName string, cocoon *Cocoon) {
 
 	var cfg *initfile.File
 	if hasConfig {
-		cfg = readConfiguration()
+		var cfgErr error
+		var done bool
+		cfg, done, cfgErr = readConfiguration()
+		if done {
+			// metamorphose command is executed, log is initialized by readConfiguration
+			defer closeLogWith(&err)
+			if cfgErr != nil {
+				return ExitMorphError, cfgErr
+			}
+			return ExitSuccess, nil
+		}
+		if cfgErr != nil {
+			Initlog(logFileName, myName)
+			SetLogLevel(ParseLogLevel(logLevel))
+			defer closeLogWith(&err)
+			return ExitConfigError, cfgErr
+		}
 	} else {
 		Initlog(logFileName, myName)
 		SetLogLevel(ParseLogLevel(logLevel))
 	}
 
+	if hasConfig {
+		logConfigSources(cfg)
+	}
+
+	var instanceLock *fileLock
+	if hasConfig && getCocoonSingleInstance(cfg) != SingleInstanceOff {
+		var lockErr error
+		instanceLock, lockErr = acquireInstanceLock()
+		if lockErr == ErrAlreadyRunning && getCocoonSingleInstance(cfg) == SingleInstanceForward {
+			err := forwardArguments(os.Args[1:])
+			if err == nil {
+				LogInfo(fmt.Sprintf("Arguments are forwarded to running cocoon: %v", os.Args[1:]))
+				defer closeLogWith(&err)
+				return ExitSuccess, nil
+			}
+			LogError(err)
+		}
+		if lockErr == ErrAlreadyRunning {
+			defer closeLogWith(&err)
+			return ExitAlreadyRunning, newExitError(ExitAlreadyRunning, lockErr)
+		}
+		if lockErr != nil {
+			defer closeLogWith(&err)
+			return ExitInstanceError, newExitError(ExitInstanceError, lockErr)
+		}
+		defer instanceLock.Release()
+	}
+
 	params := os.Args[1:]
 
 	f := func(r rune) bool {
@@ -194,7 +315,7 @@ func Start(startupCmdFile, logFileName string, cocoon *Cocoon) {
 		}
 	}
 
-	defer CloseLog()
+	defer closeLogWith(&err)
 
 	LogInfo(fmt.Sprintf("Should be console attached: %v", isConsoleAttached))
 
@@ -202,12 +323,15 @@ func Start(startupCmdFile, logFileName string, cocoon *Cocoon) {
 
 	LogInfo(fmt.Sprintf("Is 64bit environment: %v", is64bit))
 
+	if hasConfig {
+		InitCocoon()
+	}
 	if cocoon == nil {
-		*cocoon = DefaultCocoon(startupCmdFile, is64bit)
+		defaultCocoon := DefaultCocoon(startupCmdFile, is64bit)
 		if hasConfig && cfg != nil {
-			InitCocoon()
-			*cocoon = NewCocoon(cfg, is64bit)
+			defaultCocoon = NewCocoon(cfg, is64bit)
 		}
+		cocoon = &defaultCocoon
 	}
 
 	LogInfo(fmt.Sprintf("Cocoon info:\n%v\n", cocoon))
@@ -228,7 +352,8 @@ func Start(startupCmdFile, logFileName string, cocoon *Cocoon) {
 	}
 
 	if stdError != nil {
-		abort("std redirector", stdError)
+		showError(fmt.Sprintf("std redirector failed: %v", stdError))
+		return ExitOutputsError, newExitError(ExitOutputsError, stdError)
 	}
 
 	os.Stdout = stdout
@@ -237,19 +362,24 @@ func Start(startupCmdFile, logFileName string, cocoon *Cocoon) {
 	if hasConfig {
 		if errs := validator.Validate(*cocoon); errs != nil {
 			showError(fmt.Sprintf("Cocoon errors: %v\n", errs))
-			os.Exit(1)
+			return ExitValidationError, newExitError(ExitValidationError, errs)
 		}
 	}
 
 	arguments := append(params, fmt.Sprintf("--cocoon-pid=%v", syscall.Getpid()))
 
-	var pipeListener *npipe.PipeListener
+	var pipeListener net.Listener
 	if cocoon.UsePipe {
 
 		pipeListener = ListenNpipe()
 		if pipeListener != nil {
 			LogInfo(fmt.Sprintf("Start NPipe listener on: %v", GetNpipeName()))
 			defer pipeListener.Close()
+			if instanceLock != nil {
+				if err := publishInstanceAddress(instanceLock, GetNpipeName()); err != nil {
+					LogError(err)
+				}
+			}
 			arguments = append(arguments, fmt.Sprintf("--cocoon-npipe=%v", GetNpipeName()))
 		}
 
@@ -272,6 +402,10 @@ func Start(startupCmdFile, logFileName string, cocoon *Cocoon) {
 	if cocoon.UsePipe {
 		procAttr.Env = append(procAttr.Env, "COCOON_PIPE="+GetNpipeName())
 	}
+	var envErr error
+	if procAttr.Env, envErr = larvaEnviron(procAttr.Env, cfg, cocoon.LarvaPath); envErr != nil {
+		return ExitConfigError, newExitError(ExitConfigError, envErr)
+	}
 	procAttr.Dir = cocoon.LarvaPath
 
 	var scripts []string
@@ -283,10 +417,18 @@ func Start(startupCmdFile, logFileName string, cocoon *Cocoon) {
 
 	_ = stdout.Sync()
 	_ = stderr.Sync()
-	larvaProcess := StartCmdScripts(scripts, procAttr)
-	if larvaProcess != nil {
-		larvaProcess.Wait()
-	}
+	// signals are forwarded to larva only, metamorphose commands and preparation stay interruptible
+	defer forwardShutdownSignals()()
+	state, err := cocoon.Supervisor.Supervise(func() *os.Process {
+		return GetLauncher().Launch(scripts, procAttr)
+	})
 	_ = stdout.Sync()
 	_ = stderr.Sync()
+
+	if err != nil {
+		return ExitLaunchError, newExitError(ExitLaunchError, err)
+	}
+	code = exitCodeOf(state)
+	LogInfo(fmt.Sprintf("Larva exit code: %v", code))
+	return code, nil
 }
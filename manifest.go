@@ -0,0 +1,244 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cocoon
+
+// cocoon.exe metamorphose verify jre8u172
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	initfile "gopkg.in/ini.v1"
+)
+
+// manifestFile is name of per-file manifest, recorded into chrysalis folder at inject time
+const manifestFile = ".cocoon.manifest"
+
+// ErrNoManifest is returned for chrysalis, installed without manifest
+var ErrNoManifest = errors.New("chrysalis has no manifest")
+
+// ManifestEntry describes one file of injected chrysalis
+type ManifestEntry struct {
+	Path   string
+	Size   int64
+	SHA256 string
+}
+
+// ManifestDrift is difference between chrysalis folder and its manifest
+type ManifestDrift struct {
+	Missing  []string
+	Modified []string
+	Extra    []string
+}
+
+// Empty returns true if chrysalis folder matches manifest
+func (d ManifestDrift) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Modified) == 0 && len(d.Extra) == 0
+}
+
+// walkChrysalis calls fn for every non-folder entry of chrysalis with slash separated relative path, manifest itself is skipped
+func walkChrysalis(root string, fn func(rel string, info os.FileInfo) error) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == manifestFile {
+			return nil
+		}
+		return fn(rel, info)
+	})
+}
+
+// symlinkDigestPrefix marks digest of symbolic link target in manifest
+const symlinkDigestPrefix = "symlink:"
+
+// manifestDigest returns size and SHA-256 of regular file, or length and prefixed SHA-256 of symbolic link target
+func manifestDigest(path string, info os.FileInfo) (int64, string, error) {
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return 0, "", err
+		}
+		sum := sha256.Sum256([]byte(filepath.ToSlash(target)))
+		return int64(len(target)), symlinkDigestPrefix + hex.EncodeToString(sum[:]), nil
+	case info.Mode().IsRegular():
+		digest, err := fileSHA256(path)
+		return info.Size(), digest, err
+	}
+	return 0, "", fmt.Errorf("%v: not a regular file or symbolic link", path)
+}
+
+// buildManifest hashes every file and symbolic link of chrysalis folder
+func buildManifest(root string) ([]ManifestEntry, error) {
+	entries := make([]ManifestEntry, 0)
+	err := walkChrysalis(root, func(rel string, info os.FileInfo) error {
+		size, digest, err := manifestDigest(filepath.Join(root, filepath.FromSlash(rel)), info)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, ManifestEntry{Path: rel, Size: size, SHA256: digest})
+		return nil
+	})
+	return entries, err
+}
+
+// writeManifest records manifest of chrysalis folder as '<sha256> <size> <path>' lines
+func writeManifest(root string) error {
+	entries, err := buildManifest(root)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, e := range entries {
+		fmt.Fprintf(&b, "%v %d %v\n", e.SHA256, e.Size, e.Path)
+	}
+	return ioutil.WriteFile(filepath.Join(root, manifestFile), []byte(b.String()), 0644)
+}
+
+// readManifest loads manifest of chrysalis folder
+func readManifest(root string) ([]ManifestEntry, error) {
+	file, err := os.Open(filepath.Join(root, manifestFile))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%v: %w", root, ErrNoManifest)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := make([]ManifestEntry, 0)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%v:%d: invalid manifest line", manifestFile, line)
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%d: %w", manifestFile, line, err)
+		}
+		entries = append(entries, ManifestEntry{Path: fields[2], Size: size, SHA256: fields[0]})
+	}
+	return entries, scanner.Err()
+}
+
+// verifyManifest compares chrysalis folder with its manifest
+func verifyManifest(root string) (ManifestDrift, error) {
+	drift := ManifestDrift{Missing: []string{}, Modified: []string{}, Extra: []string{}}
+	entries, err := readManifest(root)
+	if err != nil {
+		return drift, err
+	}
+
+	expected := make(map[string]ManifestEntry, len(entries))
+	for _, e := range entries {
+		expected[e.Path] = e
+	}
+	seen := make(map[string]bool, len(entries))
+	err = walkChrysalis(root, func(rel string, info os.FileInfo) error {
+		e, ok := expected[rel]
+		if !ok {
+			drift.Extra = append(drift.Extra, rel)
+			return nil
+		}
+		seen[rel] = true
+		size, digest, err := manifestDigest(filepath.Join(root, filepath.FromSlash(rel)), info)
+		if err != nil || size != e.Size || digest != e.SHA256 {
+			drift.Modified = append(drift.Modified, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return drift, err
+	}
+	for _, e := range entries {
+		if !seen[e.Path] {
+			drift.Missing = append(drift.Missing, e.Path)
+		}
+	}
+	sort.Strings(drift.Missing)
+	return drift, nil
+}
+
+// writeManifestDrift prints drift as 'missing|modified|extra <path>' lines
+func writeManifestDrift(drift ManifestDrift, w io.Writer) {
+	for _, v := range drift.Missing {
+		fmt.Fprintf(w, "missing  %v\n", v)
+	}
+	for _, v := range drift.Modified {
+		fmt.Fprintf(w, "modified %v\n", v)
+	}
+	for _, v := range drift.Extra {
+		fmt.Fprintf(w, "extra    %v\n", v)
+	}
+}
+
+// MetamorphoseVerify compares named (or active) chrysalis with manifest, recorded at inject time. Returns false on drift.
+func MetamorphoseVerify(name string, cfg *initfile.File, w io.Writer) bool {
+	if name == "" {
+		name = cfg.Section("chrysalis").Key("dir.version").String()
+	}
+	if name == "" {
+		reportMorphError(errors.New("there is no active chrysalis"))
+		return false
+	}
+	root := filepath.Join(GetAbsolutePath(getChrysalisBaseDir(cfg)), name)
+	if fi, err := os.Stat(root); err != nil || !fi.IsDir() {
+		reportMorphError(fmt.Errorf("chrysalis %v is not found", name))
+		return false
+	}
+
+	drift, err := verifyManifest(root)
+	if err != nil {
+		reportMorphError(err)
+		return false
+	}
+	writeManifestDrift(drift, w)
+	if !drift.Empty() {
+		reportMorphError(fmt.Errorf("chrysalis %v: %d missing, %d modified, %d extra files",
+			name, len(drift.Missing), len(drift.Modified), len(drift.Extra)))
+		return false
+	}
+	fmt.Fprintf(w, "chrysalis %v is intact\n", name)
+	return true
+}
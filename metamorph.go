@@ -27,17 +27,28 @@ package cocoon
 //	--cocoon-startup=cocoon.cmd
 //	--cocoon-loglevel=info
 //  --cocoon-logname=MyApplication
+//	--cocoon-logsink=file
 //	--cocoon-usepipe=yes
 //	--chrysalis-dir=jre8u172
 //	--larva-startup=run.cmd
+//	--supervisor-restart=on-failure
 
 // cocoon.exe metamorphose inject jre8u1777 d:\Temp\downloaded\jre8_2018-04-25.zip yes
+// cocoon metamorphose inject --strip-components=auto jdk17 /tmp/OpenJDK17U-jre_x64_linux.tar.gz
+// cocoon metamorphose inject --sha256=0c3e...9f jdk17 /tmp/OpenJDK17U-jre_x64_linux.tar.gz
+
+// cocoon.exe metamorphose rollback 1
+// cocoon.exe metamorphose remove jre8u172
+// cocoon.exe metamorphose remove --keep=2
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -56,6 +67,15 @@ func ShouldMetamorph(params []string) bool {
 
 func logMorphInfo(section, key, value string) {
 	LogWarning(fmt.Sprintf("Applyed new metamorphose: [%v] %v -> %v", section, key, value))
+	if k, err := findConfigKey(section + "." + key); err == nil {
+		warnConfigEnvOverride(k)
+	}
+}
+
+// reportMorphError logs metamorphose failure reason and prints it for user, who runs metamorphose command
+func reportMorphError(err error) {
+	LogError(err)
+	_, _ = fmt.Fprintf(os.Stderr, "metamorphose: %v\n", err)
 }
 
 // MetamorphoseCocoonStartup sets new [cocoon].startup config value
@@ -91,6 +111,17 @@ func MetamorphoseCocoonLogname(value string, cfg *initfile.File) bool {
 
 }
 
+// MetamorphoseCocoonLogsink sets new [cocoon].log.sink config value
+func MetamorphoseCocoonLogsink(value string, cfg *initfile.File) bool {
+	if value == "" {
+		return false
+	}
+	cfg.Section("cocoon").Key("log.sink").SetValue(value)
+	logMorphInfo("cocoon", "log.sink", value)
+	return true
+
+}
+
 // MetamorphoseCocoonUsepipe sets new [cocoon].usepipe value
 func MetamorphoseCocoonUsepipe(value string, cfg *initfile.File) bool {
 	if value == "" {
@@ -106,7 +137,8 @@ func MetamorphoseChrysalisDir(value string, cfg *initfile.File) bool {
 	if value == "" {
 		return false
 	}
-	baseDir := cfg.Section("chrysalis").Key("dir.base").String()
+	previous := cfg.Section("chrysalis").Key("dir.version").String()
+	baseDir := GetAbsolutePath(getChrysalisBaseDir(cfg))
 	descriptionFile := filepath.Join(baseDir, value, "chrysalis.ini")
 	if _, err := os.Stat(descriptionFile); err == nil {
 		description, descErr := initfile.Load(descriptionFile)
@@ -118,6 +150,8 @@ func MetamorphoseChrysalisDir(value string, cfg *initfile.File) bool {
 	}
 	cfg.Section("chrysalis").Key("dir.version").SetValue(value)
 	logMorphInfo("chrysalis", "dir.version", value)
+	applyChrysalisDigest(value, cfg)
+	appendChrysalisHistory(previous, value, cfg)
 	return true
 
 }
@@ -132,25 +166,27 @@ func MetamorphoseLarvaStartup(value string, cfg *initfile.File) bool {
 	return false
 }
 
-// MetamorphoseInjectChrysalis extract zip into [chrysalis].dir.base and updates config
-func MetamorphoseInjectChrysalis(injectName, injectZip, dropRuntimes string, cfg *initfile.File) bool {
+// MetamorphoseSupervisorRestart sets new [supervisor].restart config value
+func MetamorphoseSupervisorRestart(value string, cfg *initfile.File) bool {
+	if value == "" {
+		return false
+	}
+	cfg.Section("supervisor").Key("restart").SetValue(value)
+	logMorphInfo("supervisor", "restart", value)
+	return true
+}
+
+// MetamorphoseInjectChrysalis extract archive (zip, tar, tar.gz, tar.xz, tar.zst) into [chrysalis].dir.base and updates config.
+// Strip is number of leading path components or 'auto', empty value means strip.components from chrysalis.ini.
+// Archive is verified against checksum (SHA-256 hex), or against <archive>.sha256 file if checksum is empty.
+func MetamorphoseInjectChrysalis(injectName, injectZip, dropRuntimes, strip, checksum string, cfg *initfile.File) bool {
 	// if injectZip exists -> unpack into runtime folder
 	// if unpacked sucessfully -> apply new runtime into config
 	// on success and if dropRuntimes 'yes' or 'true' -> delete other runtimes
 
 	deleteOther := strings.EqualFold(dropRuntimes, "true") || strings.EqualFold(dropRuntimes, "yes")
 
-	basedir := cfg.Section("chrysalis").Key("dir.base").Validate(func(in string) string {
-		if len(in) == 0 {
-			return "runtime"
-		}
-		return in
-	})
-
-	path, errA := filepath.Abs(basedir)
-	if errA != nil {
-		return false
-	}
+	path := GetAbsolutePath(getChrysalisBaseDir(cfg))
 	zipfile, errB := filepath.Abs(injectZip)
 	if errB != nil {
 		return false
@@ -160,8 +196,34 @@ func MetamorphoseInjectChrysalis(injectName, injectZip, dropRuntimes string, cfg
 		if !zi.IsDir() {
 			if di, err2 := os.Stat(path); err2 == nil {
 				if di.IsDir() {
+					// checksum, signature and extraction use the same private copy of <zipfile>
+					private, copied, err := copyArchive(zipfile, path, stagingPrefix+injectName+"-")
+					if err != nil {
+						reportMorphError(err)
+						return false
+					}
+					defer os.Remove(private)
+
+					digest, err := verifyArchiveChecksum(zipfile, checksum, copied)
+					if err != nil {
+						reportMorphError(err)
+						return false
+					}
+					if digest == "" {
+						LogWarning(fmt.Sprintf("Chrysalis %v checksum is not verified", zipfile))
+					}
+					signer, err := verifyArchiveSignature(zipfile, copied, cfg)
+					if err != nil {
+						reportMorphError(err)
+						return false
+					}
+					if signer != "" {
+						LogWarning(fmt.Sprintf("Chrysalis %v is signed by trusted key %v", zipfile, signer))
+					}
+
 					// now we can upack <zipfile> into <path> and register with 'dir.version' = <injectName>
-					if metamorphUnpack(zipfile, path, injectName) {
+					if metamorphUnpack(private, path, injectName, strip, cfg) {
+						setChrysalisDigest(injectName, digest, cfg)
 						if MetamorphoseChrysalisDir(injectName, cfg) {
 							if deleteOther {
 								metamorphDeleteCrysalisesExcept(path, injectName)
@@ -185,7 +247,7 @@ func metamorphDeleteCrysalisesExcept(path, exceptionName string) {
 	}
 	dirs := make([]string, 0)
 	for _, v := range files {
-		if v.IsDir() && v.Name() != exceptionName {
+		if v.IsDir() && v.Name() != exceptionName && !isStagingDir(v.Name()) {
 			dirs = append(dirs, filepath.Join(path, v.Name()))
 		}
 	}
@@ -195,23 +257,314 @@ func metamorphDeleteCrysalisesExcept(path, exceptionName string) {
 	}
 
 	for _, dirName := range dirs {
-		os.RemoveAll(dirName)
+		for _, err := range removeTree(dirName) {
+			// inject is successful anyway, so failures are logged only
+			LogError(err)
+		}
+	}
+}
+
+// removeTree deletes folder with content bottom-up and returns error for every file or folder, which can not be deleted
+func removeTree(root string) []error {
+	paths := make([]string, 0)
+	errs := make([]error, 0)
+	walkErr := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if walkErr != nil {
+		errs = append(errs, walkErr)
+	}
+	for i := len(paths) - 1; i >= 0; i-- {
+		if err := os.Remove(paths[i]); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// removeChrysalis deletes chrysalis folder and forgets its digest. Every not deleted file is reported.
+func removeChrysalis(info ChrysalisInfo, cfg *initfile.File) bool {
+	errs := removeTree(info.Path)
+	for _, err := range errs {
+		reportMorphError(err)
+	}
+	setChrysalisDigest(info.Name, "", cfg)
+	if len(errs) == 0 {
+		logMorphInfo("chrysalis", "removed", info.Name)
+	}
+	if info.Active {
+		deactivateChrysalis(cfg)
+	}
+	return len(errs) == 0
+}
+
+// deactivateChrysalis switches config from removed active chrysalis to rollback target,
+// or clears [chrysalis] dir.version and sha256 if there is nothing to rollback to
+func deactivateChrysalis(cfg *initfile.File) {
+	if name, err := findRollbackChrysalis(1, cfg); err == nil && MetamorphoseChrysalisDir(name, cfg) {
+		return
+	}
+	cfg.Section("chrysalis").DeleteKey("dir.version")
+	cfg.Section("chrysalis").DeleteKey("sha256")
+	logMorphInfo("chrysalis", "dir.version", "<none>")
+	logMorphInfo("chrysalis", "sha256", "<none>")
+}
+
+// MetamorphoseRemove deletes named chrysalis, or all chrysalises except keep newest ones if keep > 0.
+// Active chrysalis is never deleted unless force is set.
+// Changed is true if config is changed, even when some files are not deleted, so config must be saved anyway.
+func MetamorphoseRemove(name string, keep int, force bool, cfg *initfile.File) (changed, success bool) {
+	if name == "" && keep < 1 {
+		reportMorphError(errors.New("chrysalis name or --keep value is required"))
+		return false, false
+	}
+	list, err := ListChrysalises(cfg)
+	if err != nil {
+		reportMorphError(err)
+		return false, false
+	}
+
+	// newest first
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Installed.After(list[j].Installed)
+	})
+
+	targets := make([]ChrysalisInfo, 0)
+	found := name == ""
+	for i, info := range list {
+		if name != "" && info.Name == name {
+			found = true
+			targets = append(targets, info)
+		} else if name == "" && i >= keep {
+			targets = append(targets, info)
+		}
+	}
+	if !found {
+		reportMorphError(fmt.Errorf("chrysalis %v is not found", name))
+		return false, false
+	}
+
+	// active chrysalis goes last, so config is switched to chrysalis, which stays installed
+	sort.SliceStable(targets, func(i, j int) bool {
+		return !targets[i].Active && targets[j].Active
+	})
+
+	success = true
+	for _, info := range targets {
+		if info.Active && !force {
+			if name != "" {
+				reportMorphError(fmt.Errorf("chrysalis %v is active, use --force to remove it", info.Name))
+				success = false
+			}
+			continue
+		}
+		// digest and active chrysalis are dropped from config, even if folder is deleted partly
+		changed = true
+		success = removeChrysalis(info, cfg) && success
+	}
+	return changed, success
+}
+
+// stagingPrefix starts names of temporary folders and archive copies in [chrysalis].dir.base, where chrysalis is verified and extracted before install
+const stagingPrefix = ".staging-"
+
+// stagingStaleAge is age of staging folder, after which it is treated as left by interrupted inject
+const stagingStaleAge = time.Hour
+
+func isStagingDir(name string) bool {
+	return strings.HasPrefix(name, stagingPrefix)
+}
+
+// verifyUnpacked checks extracted chrysalis before it is moved into place
+func verifyUnpacked(path string) error {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("chrysalis is empty")
 	}
+	descriptionFile := filepath.Join(path, "chrysalis.ini")
+	if _, err := os.Stat(descriptionFile); err == nil {
+		if _, err := initfile.Load(descriptionFile); err != nil {
+			return fmt.Errorf("invalid chrysalis.ini: %w", err)
+		}
+	}
+	return nil
 }
 
-func metamorphUnpack(zipfile, path, unpackedFolderName string) bool {
+// metamorphUnpack extracts archive into staging folder next to target and renames it into place, so target never is half-populated
+func metamorphUnpack(zipfile, path, unpackedFolderName, strip string, cfg *initfile.File) bool {
 	target := filepath.Join(path, unpackedFolderName)
 	if _, err1 := os.Stat(target); err1 == nil {
+		reportMorphError(fmt.Errorf("chrysalis %v already exists", target))
 		return false
 	}
-	err := unzip(zipfile, target)
+
+	staging, err := ioutil.TempDir(path, stagingPrefix+unpackedFolderName+"-")
 	if err != nil {
-		LogError(err)
+		reportMorphError(err)
+		return false
+	}
+
+	err = extractArchive(zipfile, staging)
+	if err == nil {
+		err = stripChrysalis(staging, strip,
+			configKeyOf(cfg, "chrysalis", "dir.64bit").String(), configKeyOf(cfg, "chrysalis", "dir.32bit").String())
+	}
+	if err == nil {
+		err = verifyUnpacked(staging)
+	}
+	if err == nil {
+		err = writeManifest(staging)
+	}
+	if err == nil {
+		err = os.Chmod(staging, 0755)
+	}
+	if err == nil {
+		err = os.Rename(staging, target)
+	}
+	if err != nil {
+		_ = os.RemoveAll(staging)
+		reportMorphError(fmt.Errorf("can not unpack %v: %w", zipfile, err))
 	}
 	return err == nil
 }
 
+// cleanupStaging removes staging folders and archive copies, left in [chrysalis].dir.base by interrupted injects.
+// It is skipped, while another process holds config lock.
+func cleanupStaging(cfg *initfile.File) {
+	// inject runs under config lock, so staging folders are not in use while lock is free
+	lock, err := acquireFileLock(GetConfigFileName()+configLockSuffix, 0)
+	if err != nil {
+		return
+	}
+	defer lock.Release()
+
+	basedir := GetAbsolutePath(getChrysalisBaseDir(cfg))
+	files, err := ioutil.ReadDir(basedir)
+	if err != nil {
+		return
+	}
+	for _, v := range files {
+		if isStagingDir(v.Name()) && time.Since(v.ModTime()) > stagingStaleAge {
+			stale := filepath.Join(basedir, v.Name())
+			if err := os.RemoveAll(stale); err != nil {
+				LogError(err)
+			} else {
+				LogWarning("Removed stale chrysalis staging folder: " + stale)
+			}
+		}
+	}
+}
+
 // MetamorphoseDate write new metamorphose date into config
 func MetamorphoseDate(cfg *initfile.File) {
 	cfg.Section("metamorphosis").Key("date").SetValue(time.Now().String())
 }
+
+// maxChrysalisHistory limits [metamorphosis.history] length
+const maxChrysalisHistory = 20
+
+// ChrysalisHistoryRecord is chrysalis activation record
+type ChrysalisHistoryRecord struct {
+	Name      string
+	Activated time.Time
+}
+
+// GetChrysalisHistory returns chrysalis activations from [metamorphosis.history], oldest first.
+// Each record is stored as '<number> = <RFC3339 time> <chrysalis name>'.
+func GetChrysalisHistory(cfg *initfile.File) []ChrysalisHistoryRecord {
+	type numbered struct {
+		number int
+		record ChrysalisHistoryRecord
+	}
+	records := make([]numbered, 0)
+	for _, key := range cfg.Section("metamorphosis.history").Keys() {
+		number, err := strconv.Atoi(key.Name())
+		if err != nil {
+			continue
+		}
+		fields := strings.SplitN(key.String(), " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		activated, err := time.Parse(time.RFC3339, fields[0])
+		if err != nil {
+			continue
+		}
+		records = append(records, numbered{number: number, record: ChrysalisHistoryRecord{Name: fields[1], Activated: activated}})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].number < records[j].number
+	})
+
+	history := make([]ChrysalisHistoryRecord, 0, len(records))
+	for _, v := range records {
+		history = append(history, v.record)
+	}
+	return history
+}
+
+func setChrysalisHistory(history []ChrysalisHistoryRecord, cfg *initfile.File) {
+	if len(history) > maxChrysalisHistory {
+		history = history[len(history)-maxChrysalisHistory:]
+	}
+	cfg.DeleteSection("metamorphosis.history")
+	section := cfg.Section("metamorphosis.history")
+	for i, record := range history {
+		section.Key(strconv.Itoa(i + 1)).SetValue(record.Activated.Format(time.RFC3339) + " " + record.Name)
+	}
+}
+
+// appendChrysalisHistory records chrysalis activation. Chrysalis, active before history was started, is recorded first, so it can be rolled back to.
+func appendChrysalisHistory(previous, name string, cfg *initfile.File) {
+	history := GetChrysalisHistory(cfg)
+	if len(history) == 0 && previous != "" && previous != name {
+		history = append(history, ChrysalisHistoryRecord{Name: previous, Activated: time.Now()})
+	}
+	history = append(history, ChrysalisHistoryRecord{Name: name, Activated: time.Now()})
+	setChrysalisHistory(history, cfg)
+}
+
+// findRollbackChrysalis returns chrysalis, activated before current one, skipping removed chrysalises
+func findRollbackChrysalis(steps int, cfg *initfile.File) (string, error) {
+	if steps < 1 {
+		return "", fmt.Errorf("invalid rollback steps: %v", steps)
+	}
+	basedir := GetAbsolutePath(getChrysalisBaseDir(cfg))
+	current := cfg.Section("chrysalis").Key("dir.version").String()
+	history := GetChrysalisHistory(cfg)
+
+	previous := current
+	for i := len(history) - 1; i >= 0; i-- {
+		name := history[i].Name
+		if name == current || name == previous {
+			continue
+		}
+		previous = name
+		if di, err := os.Stat(filepath.Join(basedir, name)); err != nil || !di.IsDir() {
+			continue
+		}
+		steps--
+		if steps == 0 {
+			return name, nil
+		}
+	}
+	return "", errors.New("no previous chrysalis to rollback to")
+}
+
+// MetamorphoseRollback reactivates chrysalis, which was active given steps before. Rollback itself is recorded into history.
+func MetamorphoseRollback(steps int, cfg *initfile.File) bool {
+	name, err := findRollbackChrysalis(steps, cfg)
+	if err != nil {
+		reportMorphError(err)
+		return false
+	}
+	return MetamorphoseChrysalisDir(name, cfg)
+}
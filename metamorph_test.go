@@ -0,0 +1,61 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cocoon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	initfile "gopkg.in/ini.v1"
+)
+
+func TestRollbackToChrysalisActiveBeforeHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cocoon-rollback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"old", "new"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := initfile.Empty()
+	cfg.Section("chrysalis").Key("dir.base").SetValue(dir)
+	cfg.Section("chrysalis").Key("dir.version").SetValue("old")
+
+	if !MetamorphoseChrysalisDir("new", cfg) {
+		t.Fatal("MetamorphoseChrysalisDir failed")
+	}
+	name, err := findRollbackChrysalis(1, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "old" {
+		t.Fatalf("rollback target is %q, expected \"old\"", name)
+	}
+}
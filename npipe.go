@@ -25,75 +25,257 @@ package cocoon
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"net"
 	"strings"
-	"syscall"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Transport is larva-to-cocoon communication channel: named pipe on windows, unix domain socket elsewhere
+type Transport interface {
+	// Address returns endpoint address, passed to larva as COCOON_PIPE
+	Address() string
+	// Listen starts listening on endpoint address
+	Listen() (net.Listener, error)
+}
+
+// maxMessageSize limits length of one protocol line
+const maxMessageSize = 1024 * 1024
+
+var (
+	transport Transport = newPlatformTransport()
 
-	"github.com/natefinch/npipe"
+	activeListener *transportListener
+	pendingEvents  = make(chan string)
+
+	sessionsMutex sync.Mutex
+	sessions      = make(map[*larvaSession]bool)
 )
 
-// GetNpipeName constructs npipe name by pid
+// larvaSession is connection, served by serveConnection. Writes are serialized, so events never interleave with replies.
+type larvaSession struct {
+	conn    net.Conn
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+// send writes message line. Zero timeout means no write deadline.
+func (s *larvaSession) send(message interface{}, timeout time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if timeout > 0 {
+		_ = s.conn.SetWriteDeadline(time.Now().Add(timeout))
+		defer s.conn.SetWriteDeadline(time.Time{})
+	}
+	return s.encoder.Encode(message)
+}
+
+func addSession(s *larvaSession) {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	sessions[s] = true
+}
+
+func removeSession(s *larvaSession) {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	delete(sessions, s)
+}
+
+func connectedSessions() []*larvaSession {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	list := make([]*larvaSession, 0, len(sessions))
+	for s := range sessions {
+		list = append(list, s)
+	}
+	return list
+}
+
+// sendToConnected sends event to every connected larva session once. Returns number of sessions, which received event.
+func sendToConnected(event Message, timeout time.Duration) int {
+	delivered := 0
+	for _, s := range connectedSessions() {
+		if err := s.send(event, timeout); err != nil {
+			LogError(err)
+			continue
+		}
+		delivered++
+	}
+	return delivered
+}
+
+// sendToSessions sends event to every connected larva session. While no session is connected, it retries until timeout.
+// Returns number of sessions, which received event.
+func sendToSessions(event Message, timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for {
+		delivered := sendToConnected(event, timeout)
+		if delivered > 0 || time.Now().After(deadline) {
+			return delivered
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// transportListener remembers Close call, so accept loop can stop
+type transportListener struct {
+	net.Listener
+	closed int32
+}
+
+func (l *transportListener) Close() error {
+	atomic.StoreInt32(&l.closed, 1)
+	if activeListener == l {
+		activeListener = nil
+	}
+	return l.Listener.Close()
+}
+
+func (l *transportListener) isClosed() bool {
+	return atomic.LoadInt32(&l.closed) != 0
+}
+
+// GetTransport returns current larva-to-cocoon transport
+func GetTransport() Transport {
+	return transport
+}
+
+// SetTransport replaces platform transport. Nil value restores the default one.
+func SetTransport(t Transport) {
+	if t == nil {
+		t = newPlatformTransport()
+	}
+	transport = t
+}
+
+// GetNpipeName returns transport endpoint address (named pipe name or unix socket path), constructed by pid
 func GetNpipeName() string {
-	pid := syscall.Getpid()
-	return fmt.Sprintf("\\\\.\\pipe\\cocoon_%v", pid)
+	return transport.Address()
 }
 
-func notifyChilds(k, v string) {
-	message := k + ":" + v
-	ln, err := npipe.Listen(GetNpipeName())
+// notifyChilds sends event to connected larva sessions, or to next connected child if there are none. Gives up after timeout, if no child connects.
+func notifyChilds(event string, payload interface{}, timeout time.Duration) {
+	eventMessage, err := NewEvent(event, payload)
+	if err != nil {
+		LogError(err)
+		return
+	}
+	data, err := json.Marshal(eventMessage)
+	if err != nil {
+		LogError(err)
+		return
+	}
+	message := string(data)
+	if activeListener != nil {
+		if sendToConnected(eventMessage, timeout) > 0 {
+			return
+		}
+		// next connected child receives message from ListenNpipe accept loop
+		select {
+		case pendingEvents <- message:
+		case <-time.After(timeout):
+			LogWarning(fmt.Sprintf("Event %v is not delivered in %v", event, timeout))
+		}
+		return
+	}
+
+	ln, err := transport.Listen()
 	if err != nil {
 		LogError(err)
 		return
 	}
+	timer := time.AfterFunc(timeout, func() {
+		// unblocks Accept
+		_ = ln.Close()
+	})
+	defer timer.Stop()
 	defer ln.Close()
 	conn, err := ln.Accept()
 	if err != nil {
+		if !timer.Stop() {
+			LogWarning(fmt.Sprintf("Event %v is not delivered in %v", event, timeout))
+			return
+		}
 		// handle error
 		LogError(err)
 		return
 	}
+	defer conn.Close()
+	_ = conn.SetWriteDeadline(time.Now().Add(timeout))
 	if _, err := fmt.Fprintln(conn, message); err != nil {
 		LogError(err)
 	}
 
 }
 
-// ListenNpipe starts npipe listener
-func ListenNpipe() *npipe.PipeListener {
-	ln, err := npipe.Listen(GetNpipeName())
+// serveConnection reads protocol lines until connection is closed and writes replies
+func serveConnection(conn net.Conn) {
+	defer conn.Close()
+	session := &larvaSession{conn: conn, encoder: json.NewEncoder(conn)}
+	addSession(session)
+	defer removeSession(session)
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), maxMessageSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		LogInfo("receive npipe message: " + line)
+		if message, err := parseMessage(line); err == nil && message.Command == "forward" {
+			// second launch, which forwards its arguments, is not larva and does not receive events
+			removeSession(session)
+		}
+		if reply := dispatchMessage(line); reply != nil {
+			if err := session.send(reply, 0); err != nil {
+				LogError(err)
+				return
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		LogError(err)
+		_ = session.send(errorReply(Message{}, err), 0)
+	}
+}
+
+// ListenNpipe starts transport listener
+func ListenNpipe() net.Listener {
+	l, err := transport.Listen()
 	if err != nil {
 		LogError(err)
 		return nil
 	}
+	ln := &transportListener{Listener: l}
+	activeListener = ln
 
-	go func(ln *npipe.PipeListener) {
+	go func(ln *transportListener) {
 		for {
 			conn, err := ln.Accept()
 			if err != nil {
+				if ln.isClosed() {
+					return
+				}
 				// handle error
 				LogError(err)
 				continue
 			}
 
-			// handle connection like any other net.Conn
-			go func(conn net.Conn) {
-				r := bufio.NewReader(conn)
-				msg, err := r.ReadString('\n')
-				if err != nil {
+			select {
+			case message := <-pendingEvents:
+				if _, err := fmt.Fprintln(conn, message); err != nil {
 					LogError(err)
-				} else {
-					LogInfo("receive npipe message: " + msg)
-				}
-				kvArray := strings.Split(msg, ":") // key-value string format: <key>:<value>
-				msgKey, msgValue := kvArray[0], kvArray[1]
-				switch msgKey {
-				case "messagebox":
-					go DefaultMessageBox("NPipe message", msgValue)
-					break
 				}
+			default:
+			}
 
-			}(conn)
+			// handle connection like any other net.Conn
+			go serveConnection(conn)
 		}
 	}(ln)
 	return ln
@@ -0,0 +1,81 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+//go:build !windows
+// +build !windows
+
+package cocoon
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// unixSocketTransport is unix domain socket <runtime dir>/cocoon_<pid>.sock
+type unixSocketTransport struct{}
+
+func newPlatformTransport() Transport {
+	return unixSocketTransport{}
+}
+
+// getRuntimeDir returns $XDG_RUNTIME_DIR or temporary folder
+func getRuntimeDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		if di, err := os.Stat(dir); err == nil && di.IsDir() {
+			return dir
+		}
+	}
+	return os.TempDir()
+}
+
+// Address constructs socket path by pid
+func (unixSocketTransport) Address() string {
+	pid := syscall.Getpid()
+	return filepath.Join(getRuntimeDir(), fmt.Sprintf("cocoon_%v.sock", pid))
+}
+
+// Listen creates socket, accessible for current user only. Stale socket of previous process with same pid is removed.
+func (t unixSocketTransport) Listen() (net.Listener, error) {
+	address := t.Address()
+	if fi, err := os.Lstat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(address)
+	}
+	ln, err := net.Listen("unix", address)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(address, 0600); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// dialTransport connects to socket of another cocoon
+func dialTransport(address string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("unix", address, timeout)
+}
@@ -0,0 +1,59 @@
+// MIT License
+//
+// Copyright (c) 2018 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+//go:build windows
+// +build windows
+
+package cocoon
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/natefinch/npipe"
+)
+
+// npipeTransport is windows named pipe \\.\pipe\cocoon_<pid>
+type npipeTransport struct{}
+
+func newPlatformTransport() Transport {
+	return npipeTransport{}
+}
+
+// Address constructs npipe name by pid
+func (npipeTransport) Address() string {
+	pid := syscall.Getpid()
+	return fmt.Sprintf("\\\\.\\pipe\\cocoon_%v", pid)
+}
+
+// Listen creates new named pipe instance
+func (t npipeTransport) Listen() (net.Listener, error) {
+	return npipe.Listen(t.Address())
+}
+
+// dialTransport connects to named pipe of another cocoon
+func dialTransport(address string, timeout time.Duration) (net.Conn, error) {
+	return npipe.DialTimeout(address, timeout)
+}
@@ -0,0 +1,59 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+//go:build !windows
+// +build !windows
+
+package cocoon
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// DefaultMessageBox prints information message into stderr. There is no message box on POSIX.
+func DefaultMessageBox(caption, text string) (result int) {
+	_, _ = fmt.Fprintf(os.Stderr, "%s: %s\n", caption, text)
+	return 0
+}
+
+// ErrorMessageBox prints error message into stderr
+func ErrorMessageBox(text string) (result int) {
+	return DefaultMessageBox("Error", text)
+}
+
+// AttachConsole is a stub: process inherits console (terminal) on POSIX
+func AttachConsole() (ok bool) {
+	return false
+}
+
+// FreeConsole is a stub: process inherits console (terminal) on POSIX
+func FreeConsole() (ok bool) {
+	return true
+}
+
+// Is64bitOS returns true for 64bit builds. There is no WOW64 analogue on POSIX.
+func Is64bitOS() bool {
+	return strconv.IntSize == 64
+}
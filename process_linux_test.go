@@ -0,0 +1,83 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cocoon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestKillLarvaReapsGrandchildren(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cocoon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pidFile := filepath.Join(dir, "grandchild.pid")
+	script := filepath.Join(dir, "larva.sh")
+	body := fmt.Sprintf("sleep 60 &\necho $! > %s\nwait\n", shellQuote(pidFile))
+	if err := ioutil.WriteFile(script, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	attr := &os.ProcAttr{Dir: dir, Files: []*os.File{nil, os.Stdout, os.Stderr}}
+	process := GetLauncher().Launch([]string{script}, attr)
+	if process == nil {
+		t.Fatal("larva is not started")
+	}
+
+	grandchild := 0
+	for deadline := time.Now().Add(5 * time.Second); grandchild == 0; {
+		if data, err := ioutil.ReadFile(pidFile); err == nil {
+			grandchild, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("grandchild is not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := killLarva(process); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := process.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	reapLarvaTree(process, true)
+
+	if _, err := os.Stat(fmt.Sprintf("/proc/%d", grandchild)); !os.IsNotExist(err) {
+		t.Errorf("grandchild %v is not reaped", grandchild)
+	}
+	if err := syscall.Kill(-process.Pid, 0); err != syscall.ESRCH {
+		t.Errorf("larva process group %v still exists: %v", process.Pid, err)
+	}
+}
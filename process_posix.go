@@ -0,0 +1,71 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+//go:build !windows
+// +build !windows
+
+package cocoon
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// shutdownSignals are forwarded to larva
+var shutdownSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT}
+
+// reapTimeout limits waiting for killed larva descendants
+const reapTimeout = 5 * time.Second
+
+// interruptLarva sends SIGTERM to larva process group, created by StartShScripts
+func interruptLarva(process *os.Process) error {
+	return syscall.Kill(-process.Pid, syscall.SIGTERM)
+}
+
+// killLarva sends SIGKILL to larva process group, so all larva descendants are killed
+func killLarva(process *os.Process) error {
+	return syscall.Kill(-process.Pid, syscall.SIGKILL)
+}
+
+// reapLarvaTree collects exited larva descendants, reparented to cocoon as subreaper.
+// Larva process itself must be waited already. After kill it waits until larva process group disappears.
+// Terminal, given to larva process group, is taken back first.
+func reapLarvaTree(process *os.Process, killed bool) {
+	restoreForeground()
+	deadline := time.Now().Add(reapTimeout)
+	for {
+		var ws syscall.WaitStatus
+		pid, err := syscall.Wait4(-process.Pid, &ws, syscall.WNOHANG, nil)
+		if err == syscall.EINTR || (err == nil && pid > 0) {
+			continue
+		}
+		if !killed || time.Now().After(deadline) {
+			return
+		}
+		if err := syscall.Kill(-process.Pid, 0); err == syscall.ESRCH {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
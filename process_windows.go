@@ -0,0 +1,178 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+//go:build windows
+// +build windows
+
+package cocoon
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// shutdownSignals are Ctrl-C, Ctrl-Break and console close events, forwarded to larva
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+var (
+	larvaJobsMutex sync.Mutex
+	larvaJobs      = map[int]windows.Handle{}
+
+	procQueryInformationJobObject = modkernel32.NewProc("QueryInformationJobObject")
+)
+
+// jobObjectBasicAccountingInformation is JOBOBJECT_BASIC_ACCOUNTING_INFORMATION
+type jobObjectBasicAccountingInformation struct {
+	TotalUserTime             int64
+	TotalKernelTime           int64
+	ThisPeriodTotalUserTime   int64
+	ThisPeriodTotalKernelTime int64
+	TotalPageFaultCount       uint32
+	TotalProcesses            uint32
+	ActiveProcesses           uint32
+	TotalTerminatedProcesses  uint32
+}
+
+const jobObjectBasicAccountingInformationClass = 1
+
+// resumeProcess resumes threads of process, started with CREATE_SUSPENDED flag
+func resumeProcess(process *os.Process) error {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPTHREAD, 0)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(snapshot)
+
+	entry := windows.ThreadEntry32{Size: uint32(unsafe.Sizeof(windows.ThreadEntry32{}))}
+	resumed := false
+	for err = windows.Thread32First(snapshot, &entry); err == nil; err = windows.Thread32Next(snapshot, &entry) {
+		if entry.OwnerProcessID != uint32(process.Pid) {
+			continue
+		}
+		thread, err := windows.OpenThread(windows.THREAD_SUSPEND_RESUME, false, entry.ThreadID)
+		if err != nil {
+			return err
+		}
+		_, err = windows.ResumeThread(thread)
+		_ = windows.CloseHandle(thread)
+		if err != nil {
+			return err
+		}
+		resumed = true
+	}
+	if !resumed {
+		return fmt.Errorf("no threads of process %v found", process.Pid)
+	}
+	return nil
+}
+
+// jobActiveProcesses returns number of alive processes in job
+func jobActiveProcesses(job windows.Handle) (uint32, error) {
+	var info jobObjectBasicAccountingInformation
+	r1, _, err := procQueryInformationJobObject.Call(uintptr(job), jobObjectBasicAccountingInformationClass,
+		uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info), 0)
+	if r1 == 0 {
+		return 0, err
+	}
+	return info.ActiveProcesses, nil
+}
+
+// attachLarvaJob puts larva process into new Job Object, so all its descendants can be terminated at once.
+// Job is killed on close, so larva tree does not survive cocoon.
+func attachLarvaJob(process *os.Process) error {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return err
+	}
+
+	info := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{}
+	info.BasicLimitInformation.LimitFlags = windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE
+	if _, err := windows.SetInformationJobObject(job, windows.JobObjectExtendedLimitInformation,
+		uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info))); err != nil {
+		_ = windows.CloseHandle(job)
+		return err
+	}
+
+	handle, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(process.Pid))
+	if err != nil {
+		_ = windows.CloseHandle(job)
+		return err
+	}
+	defer windows.CloseHandle(handle)
+
+	if err := windows.AssignProcessToJobObject(job, handle); err != nil {
+		_ = windows.CloseHandle(job)
+		return err
+	}
+
+	larvaJobsMutex.Lock()
+	larvaJobs[process.Pid] = job
+	larvaJobsMutex.Unlock()
+	return nil
+}
+
+// interruptLarva sends Ctrl-Break to larva process group, created with createNewProcessGroup flag.
+// Console events reach only processes of the same console, and larva has own hidden console (createNoWindow),
+// so cocoon attaches to larva console for the time of the call and then returns to parent console.
+func interruptLarva(process *os.Process) error {
+	FreeConsole()
+	defer attachConsole(attachParentProcess)
+	if !attachConsole(uint32(process.Pid)) {
+		return fmt.Errorf("can not attach to console of larva process %v", process.Pid)
+	}
+	defer FreeConsole()
+	return windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(process.Pid))
+}
+
+// killLarva terminates larva Job Object with all descendants, or larva process itself, if it has no job
+func killLarva(process *os.Process) error {
+	larvaJobsMutex.Lock()
+	job, ok := larvaJobs[process.Pid]
+	larvaJobsMutex.Unlock()
+	if !ok {
+		return process.Kill()
+	}
+	return windows.TerminateJobObject(job, 1)
+}
+
+// reapLarvaTree closes larva Job Object after kill. Job stays open after normal larva exit,
+// so processes, started by larva scripts, live until cocoon exits. Jobs without alive processes are closed.
+func reapLarvaTree(process *os.Process, killed bool) {
+	larvaJobsMutex.Lock()
+	defer larvaJobsMutex.Unlock()
+	if job, ok := larvaJobs[process.Pid]; ok && killed {
+		delete(larvaJobs, process.Pid)
+		_ = windows.CloseHandle(job)
+	}
+	for pid, job := range larvaJobs {
+		if active, err := jobActiveProcesses(job); err == nil && active == 0 {
+			delete(larvaJobs, pid)
+			_ = windows.CloseHandle(job)
+		}
+	}
+}
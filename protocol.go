@@ -0,0 +1,204 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cocoon
+
+// Larva-to-cocoon protocol: newline-delimited JSON messages.
+//
+//	-> {"version":1,"type":"request","id":"42","command":"messagebox","payload":{"caption":"Hi","text":"Hello"}}
+//	<- {"version":1,"type":"reply","id":"42","command":"messagebox"}
+//
+// Legacy '<command>:<value>' lines are accepted as requests with string payload.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ProtocolVersion is current larva-to-cocoon protocol version
+const ProtocolVersion = 1
+
+// Message types
+const (
+	MessageRequest = "request"
+	MessageReply   = "reply"
+	MessageError   = "error"
+	MessageEvent   = "event"
+)
+
+// Message is one line of larva-to-cocoon protocol
+type Message struct {
+	Version int             `json:"version"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Command string          `json:"command,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// DecodePayload unmarshals message payload into v
+func (m Message) DecodePayload(v interface{}) error {
+	if len(m.Payload) == 0 {
+		return errors.New("empty payload")
+	}
+	return json.Unmarshal(m.Payload, v)
+}
+
+// MessageHandler handles request or event. Returned value is reply payload, error is sent as error reply.
+type MessageHandler func(message Message) (interface{}, error)
+
+var (
+	handlersMutex sync.RWMutex
+	handlers      = map[string]MessageHandler{
+		"messagebox": messageboxHandler,
+		"ping":       pingHandler,
+		"forward":    forwardHandler,
+	}
+)
+
+// RegisterHandler registers handler for command. Nil handler unregisters command.
+func RegisterHandler(command string, handler MessageHandler) {
+	handlersMutex.Lock()
+	defer handlersMutex.Unlock()
+	if handler == nil {
+		delete(handlers, command)
+		return
+	}
+	handlers[command] = handler
+}
+
+func getHandler(command string) MessageHandler {
+	handlersMutex.RLock()
+	defer handlersMutex.RUnlock()
+	return handlers[command]
+}
+
+// NewEvent creates cocoon-to-larva event message
+func NewEvent(command string, payload interface{}) (Message, error) {
+	message := Message{Version: ProtocolVersion, Type: MessageEvent, Command: command}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return message, err
+		}
+		message.Payload = data
+	}
+	return message, nil
+}
+
+func errorReply(request Message, err error) *Message {
+	return &Message{Version: ProtocolVersion, Type: MessageError, ID: request.ID, Command: request.Command, Error: err.Error()}
+}
+
+// parseMessage parses JSON or legacy '<command>:<value>' line
+func parseMessage(line string) (Message, error) {
+	var message Message
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "{") {
+		if err := json.Unmarshal([]byte(line), &message); err != nil {
+			return message, fmt.Errorf("malformed message: %v", err)
+		}
+		return message, nil
+	}
+
+	kvArray := strings.SplitN(line, ":", 2) // key-value string format: <key>:<value>
+	if len(kvArray) != 2 || kvArray[0] == "" {
+		return message, errors.New("malformed message: expected JSON object or '<command>:<value>'")
+	}
+	payload, _ := json.Marshal(kvArray[1])
+	return Message{Version: ProtocolVersion, Type: MessageRequest, Command: kvArray[0], Payload: payload}, nil
+}
+
+// dispatchMessage handles one protocol line and returns reply. Nil reply means nothing to send.
+func dispatchMessage(line string) *Message {
+	message, err := parseMessage(line)
+	if err != nil {
+		return errorReply(message, err)
+	}
+	if message.Version != ProtocolVersion {
+		return errorReply(message, fmt.Errorf("unsupported protocol version: %v", message.Version))
+	}
+	if message.Type != MessageRequest && message.Type != MessageEvent {
+		return errorReply(message, fmt.Errorf("unexpected message type: %v", message.Type))
+	}
+
+	handler := getHandler(message.Command)
+	if handler == nil {
+		if message.Type == MessageEvent {
+			return nil
+		}
+		return errorReply(message, fmt.Errorf("unknown command: %v", message.Command))
+	}
+
+	result, err := callHandler(handler, message)
+	if message.Type == MessageEvent {
+		if err != nil {
+			LogError(fmt.Sprintf("event %v failed: %v", message.Command, err))
+		}
+		return nil
+	}
+	if err != nil {
+		return errorReply(message, err)
+	}
+
+	reply := &Message{Version: ProtocolVersion, Type: MessageReply, ID: message.ID, Command: message.Command}
+	if result != nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return errorReply(message, err)
+		}
+		reply.Payload = data
+	}
+	return reply
+}
+
+// callHandler converts handler panic into error
+func callHandler(handler MessageHandler, message Message) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("command %v failed: %v", message.Command, r)
+		}
+	}()
+	return handler(message)
+}
+
+func messageboxHandler(message Message) (interface{}, error) {
+	box := struct {
+		Caption string `json:"caption"`
+		Text    string `json:"text"`
+	}{Caption: "NPipe message"}
+	if err := message.DecodePayload(&box.Text); err != nil {
+		if err := message.DecodePayload(&box); err != nil {
+			return nil, err
+		}
+	}
+	go DefaultMessageBox(box.Caption, box.Text)
+	return nil, nil
+}
+
+func pingHandler(message Message) (interface{}, error) {
+	return map[string]int{"version": ProtocolVersion}, nil
+}
@@ -0,0 +1,159 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cocoon
+
+// cocoon.exe metamorphose show --format=json
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	initfile "gopkg.in/ini.v1"
+	validator "gopkg.in/validator.v2"
+)
+
+// Sources of configuration values
+const (
+	SourceEnv     = "env"
+	SourceFile    = "file"
+	SourceDefault = "default"
+)
+
+// ConfigValue is effective value of configuration key
+type ConfigValue struct {
+	Section string `json:"section"`
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Source  string `json:"source"`
+}
+
+// ResolvedCocoon is Cocoon for one architecture with validation results
+type ResolvedCocoon struct {
+	Cocoon
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// ResolvedConfig is effective configuration, as cocoon sees it on start
+type ResolvedConfig struct {
+	ConfigFile string           `json:"configfile"`
+	Values     []ConfigValue    `json:"values"`
+	Cocoons    []ResolvedCocoon `json:"cocoons"`
+}
+
+// configValues returns value and source of every known key. Must be called before getters, because some getters store defaults into cfg.
+func configValues(cfg *initfile.File) []ConfigValue {
+	values := make([]ConfigValue, 0, len(configKeys))
+	for _, v := range configKeys {
+		value, source := configValueOf(cfg, v)
+		values = append(values, ConfigValue{Section: v.Section, Key: v.Key, Value: value, Source: source})
+	}
+	return values
+}
+
+// resolveCocoon builds Cocoon and validates its paths
+func resolveCocoon(cfg *initfile.File, is64bit bool) ResolvedCocoon {
+	resolved := ResolvedCocoon{Cocoon: NewCocoon(cfg, is64bit)}
+	if errs := validator.Validate(resolved.Cocoon); errs != nil {
+		resolved.Errors = make(map[string]string)
+		if errMap, ok := errs.(validator.ErrorMap); ok {
+			for field, fieldErrs := range errMap {
+				resolved.Errors[field] = fieldErrs.Error()
+			}
+		} else {
+			resolved.Errors[""] = errs.Error()
+		}
+	}
+	return resolved
+}
+
+// ResolveConfig loads configuration file and resolves Cocoon for both architectures
+func ResolveConfig() (ResolvedConfig, error) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return ResolvedConfig{}, err
+	}
+	InitCocoon()
+
+	resolved := ResolvedConfig{
+		ConfigFile: GetConfigFileName(),
+		Values:     configValues(cfg),
+		Cocoons:    []ResolvedCocoon{resolveCocoon(cfg, true), resolveCocoon(cfg, false)},
+	}
+	return resolved, nil
+}
+
+func writeResolvedConfig(resolved ResolvedConfig, format string, w io.Writer) error {
+	if format == FormatJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(resolved)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Config file:\t%v\n\n", resolved.ConfigFile)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+	for _, v := range resolved.Values {
+		fmt.Fprintf(tw, "%v.%v\t%v\t%v\n", v.Section, v.Key, v.Value, v.Source)
+	}
+	for _, c := range resolved.Cocoons {
+		fmt.Fprintf(tw, "\n[%v]\n", c.ArchStr)
+		fmt.Fprintf(tw, "Cocoon path:\t%v\t%v\n", c.Path, c.Errors["Path"])
+		fmt.Fprintf(tw, "Cocoon init script:\t%v\t\n", c.Startup)
+		fmt.Fprintf(tw, "Chrysalis path:\t%v\t%v\n", c.ChrystalisPath, c.Errors["ChrystalisPath"])
+		fmt.Fprintf(tw, "Chrysalis init script:\t%v\t\n", c.ChrystalisStartup)
+		fmt.Fprintf(tw, "Larva path:\t%v\t%v\n", c.LarvaPath, c.Errors["LarvaPath"])
+		fmt.Fprintf(tw, "Larva startup script:\t%v\t%v\n", c.LarvaStartup, c.Errors["LarvaStartup"])
+		fmt.Fprintf(tw, "Use pipe:\t%v\t\n", c.UsePipe)
+		fmt.Fprintf(tw, "Supervisor:\t%v\t\n", c.Supervisor)
+
+		fields := make([]string, 0, len(c.Errors))
+		for field := range c.Errors {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		if len(fields) == 0 {
+			fmt.Fprintln(tw, "Validation:\tok\t")
+		} else {
+			fmt.Fprintf(tw, "Validation:\tfailed %v\t\n", fields)
+		}
+	}
+	return tw.Flush()
+}
+
+// MetamorphoseShow prints effective configuration with sources of values and validation results
+func MetamorphoseShow(format string, w io.Writer) bool {
+	resolved, err := ResolveConfig()
+	if err != nil {
+		reportMorphError(err)
+		return false
+	}
+	if err := writeResolvedConfig(resolved, format, w); err != nil {
+		reportMorphError(err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cocoon
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"time"
+)
+
+// Stop notifies larva with 'die' event (if cocoon pipe is used), interrupts larva process group and kills whole larva tree after grace period
+func Stop() {
+	atomic.StoreInt32(&supervisorStopped, 1)
+
+	larvaMutex.Lock()
+	process, done, supervisor := larvaProcess, larvaDone, activeSupervisor
+	larvaMutex.Unlock()
+	if process == nil {
+		return
+	}
+
+	select {
+	case <-done:
+	default:
+		if activeListener != nil {
+			// without cocoon pipe no larva can receive event
+			notifyChilds("die", nil, supervisor.NotifyTimeout)
+		}
+		if err := interruptLarva(process); err != nil {
+			LogWarning(fmt.Sprintf("Larva process %v interrupt failed: %v", process.Pid, err))
+		}
+
+		select {
+		case <-done:
+		case <-time.After(supervisor.GracePeriod):
+			LogWarning(fmt.Sprintf("Larva process %v is alive after %v, kill it", process.Pid, supervisor.GracePeriod))
+			if err := killLarva(process); err != nil {
+				LogError(err)
+			}
+			select {
+			case <-done:
+				reapLarvaTree(process, true)
+			case <-time.After(supervisor.GracePeriod):
+				LogError(fmt.Sprintf("Larva process %v is alive after kill", process.Pid))
+			}
+		}
+	}
+
+	larvaMutex.Lock()
+	if larvaProcess == process {
+		larvaProcess = nil
+	}
+	larvaMutex.Unlock()
+}
+
+// forwardShutdownSignals stops larva on cocoon shutdown signals. Returns function, which cancels forwarding.
+func forwardShutdownSignals() func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, shutdownSignals...)
+	go func() {
+		for sig := range signals {
+			LogWarning(fmt.Sprintf("Received %v, stop larva", sig))
+			go Stop()
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+	}
+}
@@ -0,0 +1,149 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cocoon
+
+// Chrysalis archives are signed by Ed25519 over 'cocoon-chrysalis-sha256:<archive SHA-256 hex>' message,
+// signature is stored base64 encoded in <archive>.sig file. Trusted public keys are base64 values of
+// [signature] section 'key.<name>' keys. If there are no trusted keys, signatures are not checked.
+//
+// cocoon.exe metamorphose keygen d:\keys\buildserver.key
+// cocoon.exe metamorphose sign d:\keys\buildserver.key d:\Temp\jre8_2018-04-25.zip
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	initfile "gopkg.in/ini.v1"
+)
+
+const (
+	signatureSuffix  = ".sig"
+	publicKeySuffix  = ".pub"
+	signatureContext = "cocoon-chrysalis-sha256:"
+)
+
+// ErrSignatureInvalid is returned when archive signature is not made by any trusted key
+var ErrSignatureInvalid = errors.New("signature is not valid for any trusted key")
+
+func signatureMessage(digest string) []byte {
+	return []byte(signatureContext + digest)
+}
+
+func readBase64File(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+}
+
+func writeBase64File(name string, data []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(name, []byte(base64.StdEncoding.EncodeToString(data)+"\n"), perm)
+}
+
+// getTrustedKeys returns public keys from [signature] section
+func getTrustedKeys(cfg *initfile.File) (map[string]ed25519.PublicKey, error) {
+	keys := make(map[string]ed25519.PublicKey)
+	for _, key := range cfg.Section("signature").Keys() {
+		if !strings.HasPrefix(key.Name(), "key.") {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key.String()))
+		if err != nil || len(decoded) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid trusted key [signature] %v", key.Name())
+		}
+		keys[strings.TrimPrefix(key.Name(), "key.")] = ed25519.PublicKey(decoded)
+	}
+	return keys, nil
+}
+
+// verifyArchiveSignature checks <archive>.sig of archive digest with trusted keys. Returns name of key, which made signature,
+// or empty string if there are no trusted keys configured.
+func verifyArchiveSignature(archive, digest string, cfg *initfile.File) (string, error) {
+	keys, err := getTrustedKeys(cfg)
+	if err != nil || len(keys) == 0 {
+		return "", err
+	}
+
+	signature, err := readBase64File(archive + signatureSuffix)
+	if err != nil {
+		return "", fmt.Errorf("can not read signature of %v: %w", archive, err)
+	}
+	message := signatureMessage(digest)
+	for name, key := range keys {
+		if ed25519.Verify(key, message, signature) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("%v: %w", archive, ErrSignatureInvalid)
+}
+
+// MetamorphoseKeygen creates Ed25519 private key file and <file>.pub public key file, prints public key
+func MetamorphoseKeygen(keyFile string, w io.Writer) bool {
+	if _, err := os.Stat(keyFile); err == nil {
+		reportMorphError(fmt.Errorf("key file %v already exists", keyFile))
+		return false
+	}
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err == nil {
+		err = writeBase64File(keyFile, private, 0600)
+	}
+	if err == nil {
+		err = writeBase64File(keyFile+publicKeySuffix, public, 0644)
+	}
+	if err != nil {
+		reportMorphError(err)
+		return false
+	}
+	fmt.Fprintf(w, "key.<name> = %v\n", base64.StdEncoding.EncodeToString(public))
+	return true
+}
+
+// MetamorphoseSign signs archive with private key file and writes <archive>.sig
+func MetamorphoseSign(keyFile, archive string, w io.Writer) bool {
+	private, err := readBase64File(keyFile)
+	if err == nil && len(private) != ed25519.PrivateKeySize {
+		err = fmt.Errorf("%v is not Ed25519 private key", keyFile)
+	}
+	var digest string
+	if err == nil {
+		digest, err = fileSHA256(archive)
+	}
+	if err == nil {
+		err = writeBase64File(archive+signatureSuffix, ed25519.Sign(ed25519.PrivateKey(private), signatureMessage(digest)), 0644)
+	}
+	if err != nil {
+		reportMorphError(err)
+		return false
+	}
+	fmt.Fprintf(w, "%v\n", archive+signatureSuffix)
+	return true
+}
@@ -36,6 +36,17 @@ var (
 	warnLog *log.Logger
 )
 
+// getLogsFolder returns 'logs' subfolder of given folder if it exists, otherwise folder itself
+func getLogsFolder(folder string) string {
+	possibleLogSubdirName := filepath.Join(folder, "logs")
+	if di, err := os.Stat(possibleLogSubdirName); err == nil {
+		if di.IsDir() {
+			return possibleLogSubdirName
+		}
+	}
+	return folder
+}
+
 // GetOutputs creates stdout and stderr files in specific folder and returns file handlers
 func GetOutputs(folder string, filenamePrefix string) (stdout *os.File, stderr *os.File, err error) {
 	path, err := filepath.Abs(folder)
@@ -43,12 +54,7 @@ func GetOutputs(folder string, filenamePrefix string) (stdout *os.File, stderr *
 		return nil, nil, err
 	}
 
-	possibleLogSubdirName := filepath.Join(path, "logs")
-	if di, err := os.Stat(possibleLogSubdirName); err == nil {
-		if di.IsDir() {
-			path = possibleLogSubdirName
-		}
-	}
+	path = getLogsFolder(path)
 
 	stdoutName := filepath.Join(path, filenamePrefix+".stdout")
 	stderrName := filepath.Join(path, filenamePrefix+".stderr")
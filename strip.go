@@ -0,0 +1,187 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cocoon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	initfile "gopkg.in/ini.v1"
+)
+
+// StripAuto strips single folder, which wraps whole chrysalis archive
+const StripAuto = "auto"
+
+// chrysalisDescription is chrysalis.ini, it survives stripping
+const chrysalisDescription = "chrysalis.ini"
+
+// readStripComponents returns strip.components value of extracted chrysalis.ini,
+// placed in archive root or in single wrapping folder
+func readStripComponents(root string) string {
+	candidates := []string{filepath.Join(root, chrysalisDescription)}
+	if wrapper := singleWrappingDir(root); wrapper != "" {
+		candidates = append(candidates, filepath.Join(root, wrapper, chrysalisDescription))
+	}
+	for _, descriptionFile := range candidates {
+		if _, err := os.Stat(descriptionFile); err != nil {
+			continue
+		}
+		if description, err := initfile.Load(descriptionFile); err == nil {
+			return description.Section("").Key("strip.components").String()
+		}
+	}
+	return ""
+}
+
+// singleWrappingDir returns name of the only folder in root, or empty string if root contains anything else
+func singleWrappingDir(root string) string {
+	files, err := ioutil.ReadDir(root)
+	if err != nil || len(files) != 1 || !files[0].IsDir() {
+		return ""
+	}
+	return files[0].Name()
+}
+
+// stripChrysalis applies strip spec (number of leading path components or 'auto') to extracted chrysalis.
+// Auto mode strips single wrapping folder, unless it is one of keepDirs (chrysalis arch folders).
+func stripChrysalis(root, spec string, keepDirs ...string) error {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		spec = readStripComponents(root)
+	}
+
+	switch {
+	case spec == "" || spec == "0":
+		return nil
+	case strings.EqualFold(spec, StripAuto):
+		wrapper := singleWrappingDir(root)
+		if wrapper == "" {
+			return nil
+		}
+		for _, keep := range keepDirs {
+			if keep != "" && strings.EqualFold(wrapper, keep) {
+				return nil
+			}
+		}
+		LogInfo(fmt.Sprintf("Strip wrapping folder %v", wrapper))
+		if err := stripOneLevel(root); err != nil {
+			return err
+		}
+		return checkStrippedSymlinks(root)
+	}
+
+	n, err := strconv.Atoi(spec)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid strip components value: %q", spec)
+	}
+	for i := 0; i < n; i++ {
+		if err := stripOneLevel(root); err != nil {
+			return err
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	return checkStrippedSymlinks(root)
+}
+
+// checkStrippedSymlinks checks targets of symbolic links again, because stripping moves links closer to root
+func checkStrippedSymlinks(root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			return err
+		}
+		linkname, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		if err := checkSymlinkTarget(root, path, linkname); err != nil {
+			return fmt.Errorf("strip components: %v -> %v: %w", path, linkname, err)
+		}
+		return nil
+	})
+}
+
+// stripOneLevel moves content of every root subfolder into root, like 'tar --strip-components=1'.
+// Root files are dropped, except chrysalis.ini.
+func stripOneLevel(root string) error {
+	files, err := ioutil.ReadDir(root)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempDir(root, ".strip-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, v := range files {
+		path := filepath.Join(root, v.Name())
+		switch {
+		case v.IsDir():
+			if err := os.Rename(path, filepath.Join(tmp, v.Name())); err != nil {
+				return err
+			}
+		case v.Name() == chrysalisDescription:
+			// kept in place
+		default:
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	dirs, err := ioutil.ReadDir(tmp)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		children, err := ioutil.ReadDir(filepath.Join(tmp, dir.Name()))
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			target := filepath.Join(root, child.Name())
+			if _, err := os.Lstat(target); err == nil {
+				if child.Name() == chrysalisDescription {
+					// wrapped description replaces root one
+					if err := os.Remove(target); err != nil {
+						return err
+					}
+				} else {
+					return fmt.Errorf("strip components: duplicate path %v", child.Name())
+				}
+			}
+			if err := os.Rename(filepath.Join(tmp, dir.Name(), child.Name()), target); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
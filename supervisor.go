@@ -0,0 +1,171 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cocoon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Restart policies, accepted by [supervisor].restart config value
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+// ErrSupervisorStopped is returned when cocoon is stopped before larva is started
+var ErrSupervisorStopped = errors.New("cocoon is stopped before larva start")
+
+// Supervisor describes when and how often larva process is restarted
+type Supervisor struct {
+	Policy      string
+	MaxRestarts int           // max restarts within Window, 0 is unlimited
+	Window      time.Duration // restarts counting window
+	Delay       time.Duration // first restart delay, doubled on each next restart within Window
+	MaxDelay    time.Duration
+
+	GracePeriod   time.Duration // time for larva to exit after interrupt, before kill
+	NotifyTimeout time.Duration // time to deliver 'die' event to larva
+}
+
+func (s Supervisor) String() string {
+	return fmt.Sprintf("%v (max %v in %v, delay %v..%v), shutdown grace %v, notify timeout %v",
+		s.Policy, s.MaxRestarts, s.Window, s.Delay, s.MaxDelay, s.GracePeriod, s.NotifyTimeout)
+}
+
+// DefaultSupervisor returns supervisor without restarts
+func DefaultSupervisor() Supervisor {
+	return Supervisor{
+		Policy:      RestartNever,
+		MaxRestarts: 5,
+		Window:      time.Minute,
+		Delay:       time.Second,
+		MaxDelay:    30 * time.Second,
+
+		GracePeriod:   10 * time.Second,
+		NotifyTimeout: 3 * time.Second,
+	}
+}
+
+var (
+	supervisorStopped int32
+
+	larvaMutex       sync.Mutex
+	larvaProcess     *os.Process
+	larvaDone        chan struct{}
+	activeSupervisor = DefaultSupervisor()
+)
+
+// shouldRestart checks policy against larva exit state. Nil state means larva was not started or Wait failed.
+func (s Supervisor) shouldRestart(state *os.ProcessState) bool {
+	if atomic.LoadInt32(&supervisorStopped) != 0 {
+		return false
+	}
+	switch strings.ToLower(s.Policy) {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return state == nil || !state.Success()
+	}
+	return false
+}
+
+// backoff returns delay before next restart, when restarts happened within window already
+func (s Supervisor) backoff(restarts int) time.Duration {
+	delay := s.Delay
+	for i := 0; i < restarts && delay < s.MaxDelay; i++ {
+		delay *= 2
+	}
+	if s.MaxDelay > 0 && delay > s.MaxDelay {
+		delay = s.MaxDelay
+	}
+	return delay
+}
+
+// Supervise starts larva with launch function and restarts it according to policy.
+// Returns last larva exit state, or error if last larva was not started or waited.
+func (s Supervisor) Supervise(launch func() *os.Process) (*os.ProcessState, error) {
+	if atomic.LoadInt32(&supervisorStopped) != 0 {
+		return nil, ErrSupervisorStopped
+	}
+	var restarts []time.Time
+	for {
+		var state *os.ProcessState
+		var err error
+		process := launch()
+		if process != nil {
+			done := make(chan struct{})
+			larvaMutex.Lock()
+			larvaProcess, larvaDone, activeSupervisor = process, done, s
+			larvaMutex.Unlock()
+			if atomic.LoadInt32(&supervisorStopped) != 0 {
+				// Stop was called while larva was launched, so it did not see larva process
+				go Stop()
+			}
+
+			state, err = process.Wait()
+			reapLarvaTree(process, false)
+			close(done)
+			if err != nil {
+				LogError(err)
+			} else {
+				LogWarning(fmt.Sprintf("Larva process %v exited: %v", process.Pid, state))
+			}
+		} else {
+			err = errors.New("larva process is not started")
+		}
+
+		if !s.shouldRestart(state) {
+			return state, err
+		}
+
+		now := time.Now()
+		recent := restarts[:0]
+		for _, t := range restarts {
+			if s.Window <= 0 || now.Sub(t) < s.Window {
+				recent = append(recent, t)
+			}
+		}
+		restarts = recent
+
+		if s.MaxRestarts > 0 && len(restarts) >= s.MaxRestarts {
+			LogError(fmt.Sprintf("Larva restarted %v times within %v, giving up", len(restarts), s.Window))
+			return state, err
+		}
+
+		delay := s.backoff(len(restarts))
+		LogWarning(fmt.Sprintf("Restart larva (policy %v, restart %v) in %v", s.Policy, len(restarts)+1, delay))
+		time.Sleep(delay)
+		if atomic.LoadInt32(&supervisorStopped) != 0 {
+			return state, err
+		}
+		restarts = append(restarts, time.Now())
+	}
+}
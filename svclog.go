@@ -29,11 +29,6 @@ based on https://github.com/google/logger
 
 import (
 	"fmt"
-	"strings"
-
-	"golang.org/x/sys/windows"
-
-	"golang.org/x/sys/windows/svc/eventlog"
 )
 
 // Severity is log severity level.
@@ -47,6 +42,33 @@ const (
 	sFatal
 )
 
+func (level Severity) String() string {
+	switch level {
+	case sInfo:
+		return "INFO"
+	case sWarning:
+		return "WARNING"
+	case sError:
+		return "ERROR"
+	case sFatal:
+		return "FATAL"
+	}
+	return fmt.Sprintf("SEVERITY(%d)", int(level))
+}
+
+// syslogPriority returns syslog(3) priority of severity level
+func (level Severity) syslogPriority() int {
+	switch level {
+	case sInfo:
+		return 6
+	case sWarning:
+		return 4
+	case sError:
+		return 3
+	}
+	return 2
+}
+
 type prependedRecord struct {
 	Level Severity
 	Text  string
@@ -54,7 +76,7 @@ type prependedRecord struct {
 
 var (
 	logLevel      = sWarning
-	svclogWriter  *eventlog.Log
+	logSink       LogSink
 	regTitle      = "Cocoon"
 	messagePrefix = ""
 	buffer        []prependedRecord
@@ -84,45 +106,42 @@ func GetLogLevel() Severity {
 	return logLevel
 }
 
-func newWriter(src string) (*eventlog.Log, error) {
-	// Continue if we receive "registry key already exists" or if we get
-	// ERROR_ACCESS_DENIED so that we can log without administrative permissions
-	// for pre-existing eventlog sources.
-	if err := eventlog.InstallAsEventCreate(src, eventlog.Info|eventlog.Warning|eventlog.Error); err != nil {
-		if !strings.Contains(err.Error(), "registry key already exists") && err != windows.ERROR_ACCESS_DENIED {
-			return nil, err
-		}
-	}
-	el, err := eventlog.Open(src)
-	if err != nil {
-		return nil, err
-	}
-	return el, nil
+// Initlog log initialization with platform default sink
+func Initlog(title string, exeFileName string) {
+	InitlogSink(defaultLogSink, title, exeFileName)
 }
 
-// Initlog log initialization
-func Initlog(title string, exeFileName string) {
+// InitlogSink log initialization with sink, named as in [cocoon].log.sink. Falls back to stderr sink if requested one is unavailable.
+func InitlogSink(sinkName, title string, exeFileName string) {
 	regTitle = title
 	messagePrefix = exeFileName + ": "
-	w, err := newWriter(regTitle)
+	sink, err := NewLogSink(sinkName, regTitle)
 	if err != nil {
-		abort("InitLog", err)
+		sink = newStderrSink(regTitle)
+		_ = sink.Write(sError, messagePrefix+fmt.Sprintf("log sink '%v' failed: %v", sinkName, err))
 	}
-	svclogWriter = w
+	SetLogSink(sink)
+}
 
-	if buffer != nil && len(buffer) > 0 {
+// SetLogSink replaces current log sink and flushes records, written before log initialization
+func SetLogSink(sink LogSink) {
+	if logSink != nil && logSink != sink {
+		_ = logSink.Close()
+	}
+	logSink = sink
+
+	if sink != nil && len(buffer) > 0 {
 		for _, record := range buffer {
 			writeToLog(record.Level, record.Text)
 		}
 		buffer = nil
 	}
-
 }
 
 // CloseLog close log.
 func CloseLog() {
-	if svclogWriter != nil {
-		_ = svclogWriter.Close()
+	if logSink != nil {
+		_ = logSink.Close()
 	}
 }
 
@@ -132,23 +151,16 @@ func writeToLog(level Severity, text string) {
 		return
 	}
 
-	if svclogWriter == nil {
-		buffer = append(buffer, prependedRecord{Level: level, Text: text})
-		return
+	if level != sInfo && level != sWarning && level != sError {
+		LogFatal(fmt.Sprintf("unrecognized severity: %v", level))
 	}
 
-	switch level {
-	case sInfo:
-		_ = svclogWriter.Info(1, messagePrefix+text)
-		return
-	case sWarning:
-		_ = svclogWriter.Warning(3, messagePrefix+text)
-		return
-	case sError:
-		_ = svclogWriter.Error(2, messagePrefix+text)
+	if logSink == nil {
+		buffer = append(buffer, prependedRecord{Level: level, Text: text})
 		return
 	}
-	LogFatal(fmt.Sprintf("unrecognized severity: %v", level))
+
+	_ = logSink.Write(level, messagePrefix+text)
 }
 
 // LogInfo log as information
@@ -0,0 +1,84 @@
+// MIT License
+//
+// Copyright (c) 2018 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+//go:build windows
+// +build windows
+
+package cocoon
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/windows"
+
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// defaultLogSink is used when [cocoon].log.sink is not set
+const defaultLogSink = LogSinkEventlog
+
+// eventlogSink writes records into windows event log
+type eventlogSink struct {
+	log *eventlog.Log
+}
+
+func newEventlogSink(src string) (LogSink, error) {
+	el, err := newWriter(src)
+	if err != nil {
+		return nil, err
+	}
+	return &eventlogSink{log: el}, nil
+}
+
+func (s *eventlogSink) Write(level Severity, text string) error {
+	switch level {
+	case sInfo:
+		return s.log.Info(1, text)
+	case sWarning:
+		return s.log.Warning(3, text)
+	case sError:
+		return s.log.Error(2, text)
+	}
+	return fmt.Errorf("unrecognized severity: %v", level)
+}
+
+func (s *eventlogSink) Close() error {
+	return s.log.Close()
+}
+
+func newWriter(src string) (*eventlog.Log, error) {
+	// Continue if we receive "registry key already exists" or if we get
+	// ERROR_ACCESS_DENIED so that we can log without administrative permissions
+	// for pre-existing eventlog sources.
+	if err := eventlog.InstallAsEventCreate(src, eventlog.Info|eventlog.Warning|eventlog.Error); err != nil {
+		if !strings.Contains(err.Error(), "registry key already exists") && err != windows.ERROR_ACCESS_DENIED {
+			return nil, err
+		}
+	}
+	el, err := eventlog.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	return el, nil
+}
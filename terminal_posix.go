@@ -0,0 +1,71 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+//go:build !windows
+// +build !windows
+
+package cocoon
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+// foregroundTTY is terminal, given to larva process group, or -1
+var foregroundTTY = -1
+
+func ioctlPgrp(fd int, req uintptr, pgrp *int32) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, uintptr(unsafe.Pointer(pgrp))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// isForegroundTTY returns true if file is terminal, owned by cocoon process group
+func isForegroundTTY(file *os.File) bool {
+	if file == nil {
+		return false
+	}
+	var pgrp int32
+	if err := ioctlPgrp(int(file.Fd()), syscall.TIOCGPGRP, &pgrp); err != nil {
+		return false
+	}
+	return int(pgrp) == syscall.Getpgrp()
+}
+
+// restoreForeground gives terminal back to cocoon process group after larva exit
+func restoreForeground() {
+	if foregroundTTY < 0 {
+		return
+	}
+	// cocoon is in background group now, so tcsetpgrp would stop it with SIGTTOU
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+	pgrp := int32(syscall.Getpgrp())
+	if err := ioctlPgrp(foregroundTTY, syscall.TIOCSPGRP, &pgrp); err != nil {
+		LogError(err)
+	}
+	foregroundTTY = -1
+}
@@ -25,64 +25,128 @@ package cocoon
 
 import (
 	"archive/zip"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 )
 
+// Unsafe archive errors
+var (
+	ErrUnsafePath      = errors.New("path escapes destination folder")
+	ErrSymlinkEntry    = errors.New("symbolic links are not allowed")
+	ErrTooManyEntries  = errors.New("too many entries")
+	ErrArchiveTooLarge = errors.New("uncompressed size exceeds limit")
+)
+
+// archiveLimits protects extraction from zip bombs
+type archiveLimits struct {
+	MaxEntries int
+	MaxSize    int64
+}
+
+var defaultArchiveLimits = archiveLimits{
+	MaxEntries: 200000,
+	MaxSize:    8 << 30, // 8 GiB
+}
+
+// safeArchivePath resolves archive entry name inside dest. Absolute names, drive letters and '..' traversal are rejected.
+func safeArchivePath(dest, name string) (string, error) {
+	slashed := strings.Replace(name, "\\", "/", -1)
+	if slashed == "" || strings.ContainsRune(slashed, 0) || strings.HasPrefix(slashed, "/") ||
+		filepath.IsAbs(name) || filepath.VolumeName(name) != "" || (len(slashed) > 1 && slashed[1] == ':') {
+		return "", fmt.Errorf("%q: %w", name, ErrUnsafePath)
+	}
+	cleaned := path.Clean(slashed)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("%q: %w", name, ErrUnsafePath)
+	}
+	target := filepath.Join(dest, filepath.FromSlash(cleaned))
+	if target != filepath.Clean(dest) && !strings.HasPrefix(target, filepath.Clean(dest)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("%q: %w", name, ErrUnsafePath)
+	}
+	return target, nil
+}
+
 func unzip(src, dest string) error {
+	return unzipWithLimits(src, dest, defaultArchiveLimits)
+}
+
+// closeInto closes c and keeps close error in err, unless err already holds earlier error
+func closeInto(c io.Closer, err *error) {
+	if cerr := c.Close(); cerr != nil && *err == nil {
+		*err = cerr
+	}
+}
+
+func unzipWithLimits(src, dest string, limits archiveLimits) (err error) {
 	r, err := zip.OpenReader(src)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if err := r.Close(); err != nil {
-			panic(err)
+	defer closeInto(r, &err)
+
+	if len(r.File) > limits.MaxEntries {
+		return fmt.Errorf("%v: %v entries: %w", src, len(r.File), ErrTooManyEntries)
+	}
+
+	// check declared sizes and paths before anything is written
+	var declared uint64
+	for _, f := range r.File {
+		if _, err := safeArchivePath(dest, f.Name); err != nil {
+			return err
+		}
+		if f.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%q: %w", f.Name, ErrSymlinkEntry)
+		}
+		declared += f.UncompressedSize64
+		if declared > uint64(limits.MaxSize) {
+			return fmt.Errorf("%v: %w", src, ErrArchiveTooLarge)
 		}
-	}()
+	}
 
 	err = os.MkdirAll(dest, 0755)
 	if err != nil {
 		return err
 	}
 
+	remaining := limits.MaxSize
+
 	// Closure to address file descriptors issue with all the deferred .Close() methods
-	extractAndWriteFile := func(f *zip.File) error {
+	extractAndWriteFile := func(f *zip.File) (err error) {
 		rc, err := f.Open()
 		if err != nil {
-			return err
+			return fmt.Errorf("%q: %w", f.Name, err)
 		}
-		defer func() {
-			if err := rc.Close(); err != nil {
-				panic(err)
-			}
-		}()
+		defer closeInto(rc, &err)
 
-		path := filepath.Join(dest, f.Name)
+		path, err := safeArchivePath(dest, f.Name)
+		if err != nil {
+			return err
+		}
 
 		if f.FileInfo().IsDir() {
-			err = os.MkdirAll(path, f.Mode())
+			err = os.MkdirAll(path, dirMode(f.Mode()))
 			if err != nil {
 				return err
 			}
 		} else {
-			err = os.MkdirAll(filepath.Dir(path), f.Mode())
+			err = os.MkdirAll(filepath.Dir(path), 0755)
 			if err != nil {
 				return err
 			}
-			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+			// declared size may lie, so real size is limited too
+			var written int64
+			written, err = writeArchiveFile(path, f.Mode().Perm(), io.LimitReader(rc, remaining+1))
 			if err != nil {
-				return err
+				return fmt.Errorf("%q: %w", f.Name, err)
 			}
-			defer func() {
-				if err := f.Close(); err != nil {
-					panic(err)
-				}
-			}()
-
-			_, err = io.Copy(f, rc)
-			if err != nil {
-				return err
+			remaining -= written
+			if remaining < 0 {
+				return fmt.Errorf("%v: %w", src, ErrArchiveTooLarge)
 			}
 		}
 		return nil
@@ -97,3 +161,8 @@ func unzip(src, dest string) error {
 
 	return nil
 }
+
+// dirMode makes sure extracted folder is accessible by owner
+func dirMode(mode os.FileMode) os.FileMode {
+	return mode.Perm() | 0700
+}
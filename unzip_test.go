@@ -0,0 +1,165 @@
+// MIT License
+//
+// Copyright (c) 2018-2019 Alexander Malyshev <alexript@outlook.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cocoon
+
+import (
+	"archive/zip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+type testZipEntry struct {
+	name string
+	mode os.FileMode
+	body string
+}
+
+// corruptTestZip overwrites beginning of first entry data, so deflate stream is invalid
+func corruptTestZip(t *testing.T, archive string, entries []testZipEntry) {
+	data, err := ioutil.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := 30 + len(entries[0].name) // local file header without extra field
+	for i := offset; i < offset+4; i++ {
+		data[i] = 0xff
+	}
+	if err := ioutil.WriteFile(archive, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestZip(t *testing.T, dir string, entries []testZipEntry) string {
+	name := filepath.Join(dir, "chrysalis.zip")
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	w := zip.NewWriter(file)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		mode := entry.mode
+		if mode == 0 {
+			mode = 0644
+		}
+		header.SetMode(mode)
+		fw, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(entry.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestUnzipHostileArchives(t *testing.T) {
+	many := make([]testZipEntry, 0)
+	for i := 0; i < 11; i++ {
+		many = append(many, testZipEntry{name: "file" + strconv.Itoa(i)})
+	}
+
+	tests := []struct {
+		name    string
+		entries []testZipEntry
+		corrupt bool
+		want    error // nil means any error
+	}{
+		{"traversal", []testZipEntry{{name: "../evil.txt", body: "evil"}}, false, ErrUnsafePath},
+		{"nested traversal", []testZipEntry{{name: "bin/../../evil.txt", body: "evil"}}, false, ErrUnsafePath},
+		{"backslash traversal", []testZipEntry{{name: "..\\evil.txt", body: "evil"}}, false, ErrUnsafePath},
+		{"absolute", []testZipEntry{{name: "/tmp/evil.txt", body: "evil"}}, false, ErrUnsafePath},
+		{"drive letter", []testZipEntry{{name: "C:\\evil.txt", body: "evil"}}, false, ErrUnsafePath},
+		{"symlink", []testZipEntry{{name: "link", mode: os.ModeSymlink | 0777, body: "/etc/passwd"}}, false, ErrSymlinkEntry},
+		{"too many entries", many, false, ErrTooManyEntries},
+		{"too large", []testZipEntry{{name: "bomb", body: string(make([]byte, 2048))}}, false, ErrArchiveTooLarge},
+		{"corrupt stream", []testZipEntry{{name: "broken", body: "some text, which is deflated"}}, true, nil},
+	}
+
+	limits := archiveLimits{MaxEntries: 10, MaxSize: 1024}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "cocoon")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			archive := writeTestZip(t, dir, test.entries)
+			if test.corrupt {
+				corruptTestZip(t, archive, test.entries)
+			}
+			dest := filepath.Join(dir, "runtime", "hostile")
+			err = unzipWithLimits(archive, dest, limits)
+			if test.want == nil && err == nil {
+				t.Fatal("unzip error = nil, want error")
+			}
+			if test.want != nil && !errors.Is(err, test.want) {
+				t.Fatalf("unzip error = %v, want %v", err, test.want)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "runtime", "evil.txt")); !os.IsNotExist(err) {
+				t.Errorf("file is written outside of destination")
+			}
+			if _, err := os.Stat(filepath.Join(dir, "evil.txt")); !os.IsNotExist(err) {
+				t.Errorf("file is written outside of destination")
+			}
+		})
+	}
+}
+
+func TestUnzipValidArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cocoon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := writeTestZip(t, dir, []testZipEntry{
+		{name: "x64/bin/", mode: os.ModeDir | 0755},
+		{name: "x64/bin/java", mode: 0755, body: "#!/bin/sh\n"},
+		{name: "chrysalis.ini", body: "dir.64bit=x64\n"},
+	})
+	dest := filepath.Join(dir, "runtime", "jre")
+	if err := unzip(archive, dest); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dest, "chrysalis.ini"))
+	if err != nil || string(data) != "dir.64bit=x64\n" {
+		t.Errorf("chrysalis.ini = %q, %v", data, err)
+	}
+	if fi, err := os.Stat(filepath.Join(dest, "x64", "bin", "java")); err != nil || fi.Mode().Perm()&0100 == 0 {
+		t.Errorf("java is not extracted as executable: %v", err)
+	}
+}
@@ -23,14 +23,6 @@
 
 package cocoon
 
-import "fmt"
-
-func abort(funcname string, err error) {
-	text := fmt.Sprintf("%s failed: %v", funcname, err)
-	showError(text)
-	panic(text)
-}
-
 func showError(errorText string) {
 	ErrorMessageBox(errorText)
 }
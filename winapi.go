@@ -21,6 +21,9 @@
 // SOFTWARE.
 //
 
+//go:build windows
+// +build windows
+
 package cocoon
 
 import (